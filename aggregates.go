@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/processor"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+// AggregateResource serves every metric of a resource folded into a single
+// {key: value} object, which is what UIs rendering a resource card need,
+// instead of a paginated collection of metric rows.
+type AggregateResource struct {
+	db           database.Database
+	config       *Config
+	hooks        *MetricHooks
+	errorHandler processor.ErrorHandler
+}
+
+func RegisterAggregateRoutes(router fiber.Router, db database.Database, config *Config) {
+	res := &AggregateResource{
+		db:           db,
+		config:       config,
+		hooks:        NewMetricHooks(config),
+		errorHandler: &processor.DefaultErrorHandler{},
+	}
+
+	router.Get("/metrics/resources/:resource", res.GetMany)
+	router.Get("/metrics/resources/:resource/:resourceId", res.GetOne)
+}
+
+// GetOne answers GET /metrics/resources/:resource/:resourceId with the
+// resource's metrics as a {key: value} map, optionally narrowed by ?keys=a,b.
+func (r *AggregateResource) GetOne(c fiber.Ctx) error {
+	resourceType := c.Params("resource")
+	resourceID := c.Params("resourceId")
+
+	keys, err := parseKeysQuery(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "validate")
+	}
+
+	if err := r.validate(resourceType, []string{resourceID}); err != nil {
+		return r.errorHandler.HandleError(c, err, "validate")
+	}
+
+	if err := r.authorize(c, resourceType, resourceID, keys); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	tenant, err := r.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	values, err := fetchMetricValues(auth.Context(c), r.db, tenant, resourceType, []string{resourceID}, keys)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getAll")
+	}
+	dropKeys(values, r.config.hiddenKeys(c))
+
+	return response.SendJSON(c, fiber.StatusOK, values[resourceID])
+}
+
+// GetMany answers GET /metrics/resources/:resource?resourceIds=a,b,c with one
+// {key: value} map per requested resource ID, in a single query. Every
+// requested ID is present in the response, with an empty map when it has no
+// metrics, so list pages can index it directly.
+func (r *AggregateResource) GetMany(c fiber.Ctx) error {
+	resourceType := c.Params("resource")
+	resourceIDs := splitList(c.Query("resourceIds"))
+
+	if len(resourceIDs) == 0 {
+		return r.errorHandler.HandleError(c, fiber.NewError(400, "resourceIds is required"), "validate")
+	}
+
+	if limit := r.config.BatchResourceIDsLimit(); len(resourceIDs) > limit {
+		msg := fmt.Sprintf("resourceIds cannot contain more than %d values", limit)
+		return r.errorHandler.HandleError(c, fiber.NewError(400, msg), "validate")
+	}
+
+	keys, err := parseKeysQuery(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "validate")
+	}
+
+	if err := r.validate(resourceType, resourceIDs); err != nil {
+		return r.errorHandler.HandleError(c, err, "validate")
+	}
+
+	var resourceID string
+	if len(resourceIDs) == 1 {
+		resourceID = resourceIDs[0]
+	}
+	if err := r.authorize(c, resourceType, resourceID, keys); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	tenant, err := r.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	values, err := fetchMetricValues(auth.Context(c), r.db, tenant, resourceType, resourceIDs, keys)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getAll")
+	}
+	dropKeys(values, r.config.hiddenKeys(c))
+
+	return response.SendJSON(c, fiber.StatusOK, values)
+}
+
+// authorize checks a read of resourceType; the key is only set when a single
+// one was asked for.
+func (r *AggregateResource) authorize(c fiber.Ctx, resourceType, resourceID string, keys []string) error {
+	req := AuthorizationRequest{Operation: OperationRead, ResourceType: resourceType, ResourceID: resourceID}
+	if len(keys) == 1 {
+		req.Key = keys[0]
+	}
+	return r.config.authorize(c, req)
+}
+
+func (r *AggregateResource) validate(resourceType string, resourceIDs []string) error {
+	if !r.config.IsAllowedType(resourceType) {
+		return fiber.NewError(400, "resource type is not allowed")
+	}
+
+	for _, id := range resourceIDs {
+		if err := r.hooks.validateResourceID(resourceType, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchMetricValues loads the metrics of many resources of one type in a
+// single query and folds them into resourceID -> key -> value. Every requested
+// ID gets an entry, empty when it has no metrics. An empty keys slice means all
+// keys.
+func fetchMetricValues(ctx context.Context, db database.Database, tenant, resourceType string, resourceIDs []string, keys []string) (map[string]map[string]int, error) {
+	values := make(map[string]map[string]int, len(resourceIDs))
+	ids := make([]any, 0, len(resourceIDs))
+	for _, id := range resourceIDs {
+		if _, ok := values[id]; ok {
+			continue
+		}
+		values[id] = map[string]int{}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return values, nil
+	}
+
+	qb := query.New(db.Dialect()).
+		Select("resource_id", "name", "value").
+		From(Metric{}.TableName()).
+		Where(query.Eq("tenant", tenant)).
+		Where(query.IsNull("deleted_at")).
+		Where(query.Eq("resource", resourceType)).
+		Where(query.In("resource_id", ids...))
+
+	if len(keys) > 0 {
+		keyArgs := make([]any, len(keys))
+		for i, k := range keys {
+			keyArgs[i] = k
+		}
+		qb = qb.Where(query.In("name", keyArgs...))
+	}
+
+	sqlStr, args, err := qb.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			resourceID string
+			key        string
+			value      int
+		)
+		if err := rows.Scan(&resourceID, &key, &value); err != nil {
+			return nil, err
+		}
+		if m, ok := values[resourceID]; ok {
+			m[key] = value
+		}
+	}
+
+	return values, rows.Err()
+}
+
+// dropKeys removes keys from every resource's values.
+func dropKeys(values map[string]map[string]int, keys []string) {
+	for _, byKey := range values {
+		for _, key := range keys {
+			delete(byKey, key)
+		}
+	}
+}
+
+func parseKeysQuery(c fiber.Ctx) ([]string, error) {
+	keys := splitList(c.Query("keys"))
+	if len(keys) > MaxFilterValuesPerField {
+		return nil, fiber.NewError(400, fmt.Sprintf("keys cannot contain more than %d values", MaxFilterValuesPerField))
+	}
+	return keys, nil
+}
+
+// splitList parses a comma-separated query parameter, dropping blanks.
+func splitList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+
+	parts := strings.Split(raw, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
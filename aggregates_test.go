@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAggregateTestApp(t *testing.T) (*fiber.App, func(resourceID, key string, value int)) {
+	t.Helper()
+
+	db := newTestDB(t)
+	config := DefaultConfig()
+	config.MaxBatchResourceIDs = 3
+
+	app := fiber.New()
+	RegisterAggregateRoutes(app, db, &config)
+
+	insert := func(resourceID, key string, value int) {
+		_, err := db.Exec(t.Context(),
+			rebind(db, "INSERT INTO metrics (id, resource, resource_id, name, value) VALUES (?, ?, ?, ?, ?)"),
+			uuid.New().String(), "post", resourceID, key, value)
+		require.NoError(t, err)
+	}
+
+	return app, insert
+}
+
+func doGet(t *testing.T, app *fiber.App, target string) (int, []byte) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, body
+}
+
+func TestAggregateResource_GetOne(t *testing.T) {
+	app, insert := newAggregateTestApp(t)
+
+	postID := uuid.New().String()
+	insert(postID, "views", 120)
+	insert(postID, "likes", 7)
+	insert(uuid.New().String(), "views", 1)
+
+	status, body := doGet(t, app, "/metrics/resources/post/"+postID)
+	require.Equal(t, http.StatusOK, status)
+
+	var got map[string]int
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, map[string]int{"views": 120, "likes": 7}, got)
+
+	status, body = doGet(t, app, "/metrics/resources/post/"+postID+"?keys=likes")
+	require.Equal(t, http.StatusOK, status)
+	got = nil
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, map[string]int{"likes": 7}, got)
+}
+
+func TestAggregateResource_GetMany(t *testing.T) {
+	app, insert := newAggregateTestApp(t)
+
+	first := uuid.New().String()
+	second := uuid.New().String()
+	empty := uuid.New().String()
+	insert(first, "views", 3)
+	insert(second, "views", 5)
+	insert(second, "likes", 1)
+
+	status, body := doGet(t, app, "/metrics/resources/post?resourceIds="+strings.Join([]string{first, second, empty}, ","))
+	require.Equal(t, http.StatusOK, status)
+
+	var got map[string]map[string]int
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, map[string]map[string]int{
+		first:  {"views": 3},
+		second: {"views": 5, "likes": 1},
+		empty:  {},
+	}, got)
+}
+
+func TestAggregateResource_Validation(t *testing.T) {
+	app, _ := newAggregateTestApp(t)
+
+	tooMany := make([]string, 4)
+	for i := range tooMany {
+		tooMany[i] = uuid.New().String()
+	}
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{name: "resource type not allowed", target: "/metrics/resources/comment/" + uuid.New().String()},
+		{name: "invalid resource id", target: "/metrics/resources/post/not-a-uuid"},
+		{name: "missing resource ids", target: "/metrics/resources/post"},
+		{name: "too many resource ids", target: "/metrics/resources/post?resourceIds=" + strings.Join(tooMany, ",")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := doGet(t, app, tt.target)
+			assert.Equal(t, http.StatusBadRequest, status)
+		})
+	}
+}
@@ -0,0 +1,586 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/logger"
+	"github.com/nicolasbonnici/gorest/processor"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// Comparison operators accepted by alert rules.
+const (
+	AlertOperatorGT  = "gt"
+	AlertOperatorGTE = "gte"
+	AlertOperatorLT  = "lt"
+	AlertOperatorLTE = "lte"
+	AlertOperatorEQ  = "eq"
+)
+
+// Headers set on every alert webhook. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed by the rule secret, see SignAlertPayload.
+const (
+	AlertSignatureHeader = "X-Metrics-Signature"
+	AlertTimestampHeader = "X-Metrics-Timestamp"
+)
+
+const (
+	defaultAlertCooldownSeconds = 300
+	defaultAlertQueueSize       = 256
+	defaultAlertMaxAttempts     = 3
+	defaultAlertRetryBackoff    = time.Second
+	defaultAlertTimeout         = 5 * time.Second
+	defaultAlertStateSize       = 100000
+)
+
+// AlertEvent is the JSON body POSTed to a rule's webhook when it fires.
+type AlertEvent struct {
+	RuleID     string    `json:"ruleId"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Resource   string    `json:"resource"`
+	ResourceID string    `json:"resourceId"`
+	Key        string    `json:"key"`
+	Operator   string    `json:"operator"`
+	Threshold  int       `json:"threshold"`
+	Value      int       `json:"value"`
+	MetricID   string    `json:"metricId"`
+	FiredAt    time.Time `json:"firedAt"`
+}
+
+// SignAlertPayload returns the signature a webhook receiver should compare
+// (in constant time) against the X-Metrics-Signature header.
+func SignAlertPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r AlertRule) matches(m Metric) bool {
+	return r.watches(m) && r.holds(m.Value)
+}
+
+// watches reports whether m is a metric the rule applies to.
+func (r AlertRule) watches(m Metric) bool {
+	if r.Tenant != m.Tenant || r.Resource != m.Resource || r.Key != m.Key {
+		return false
+	}
+	return r.ResourceId == "" || r.ResourceId == m.ResourceId
+}
+
+// holds reports whether value satisfies the rule's condition.
+func (r AlertRule) holds(value int) bool {
+	switch r.Operator {
+	case AlertOperatorGT:
+		return value > r.Threshold
+	case AlertOperatorGTE:
+		return value >= r.Threshold
+	case AlertOperatorLT:
+		return value < r.Threshold
+	case AlertOperatorLTE:
+		return value <= r.Threshold
+	case AlertOperatorEQ:
+		return value == r.Threshold
+	}
+	return false
+}
+
+type AlertHooks struct {
+	metricHooks *MetricHooks
+}
+
+func NewAlertHooks(config *Config) *AlertHooks {
+	return &AlertHooks{
+		metricHooks: NewMetricHooks(config),
+	}
+}
+
+func (h *AlertHooks) CreateHook(c fiber.Ctx, dto AlertRuleCreateDTO, model *AlertRule) error {
+	return h.validate(c, model)
+}
+
+func (h *AlertHooks) UpdateHook(c fiber.Ctx, dto AlertRuleUpdateDTO, model *AlertRule) error {
+	model.Id = c.Params("id")
+	return h.validate(c, model)
+}
+
+// validate checks rule and assigns it to the caller's tenant.
+func (h *AlertHooks) validate(c fiber.Ctx, rule *AlertRule) error {
+	config := h.metricHooks.config
+	if !config.IsAllowedType(rule.Resource) {
+		return fiber.NewError(400, "resource type is not allowed")
+	}
+
+	if rule.ResourceId != "" {
+		if err := h.metricHooks.validateResourceID(rule.Resource, rule.ResourceId); err != nil {
+			return err
+		}
+	}
+
+	rule.Key = strings.TrimSpace(rule.Key)
+	if rule.Key == "" {
+		return fiber.NewError(400, "key cannot be empty")
+	}
+	if len(rule.Key) > config.MaxKeyLength {
+		return fiber.NewError(400, "key exceeds maximum length")
+	}
+
+	switch rule.Operator {
+	case AlertOperatorGT, AlertOperatorGTE, AlertOperatorLT, AlertOperatorLTE, AlertOperatorEQ:
+	default:
+		return fiber.NewError(400, "operator must be one of gt, gte, lt, lte, eq")
+	}
+
+	u, err := url.Parse(rule.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fiber.NewError(400, "webhookUrl must be an absolute http(s) URL")
+	}
+
+	if rule.Secret == "" {
+		return fiber.NewError(400, "secret cannot be empty")
+	}
+
+	if rule.CooldownSeconds < 0 {
+		return fiber.NewError(400, "cooldownSeconds cannot be negative")
+	}
+	if rule.CooldownSeconds == 0 {
+		rule.CooldownSeconds = defaultAlertCooldownSeconds
+	}
+
+	tenant, err := config.tenant(c)
+	if err != nil {
+		return err
+	}
+	rule.Tenant = tenant
+
+	return nil
+}
+
+// GetAllHook scopes the listing to the caller's tenant.
+func (h *AlertHooks) GetAllHook(c fiber.Ctx, conditions *[]query.Condition, orderBy *[]crud.OrderByClause) error {
+	tenant, err := h.metricHooks.config.tenant(c)
+	if err != nil {
+		return err
+	}
+	*conditions = append(*conditions, query.Eq("tenant", tenant))
+	return nil
+}
+
+type AlertResource struct {
+	processor    processor.Processor[AlertRule, AlertRuleCreateDTO, AlertRuleUpdateDTO, AlertRuleResponseDTO]
+	crud         *crud.CRUD[AlertRule]
+	config       *Config
+	errorHandler processor.ErrorHandler
+}
+
+func RegisterAlertRoutes(router fiber.Router, db database.Database, config *Config) {
+	alertCRUD := crud.New[AlertRule](db)
+	hooks := NewAlertHooks(config)
+
+	fieldMapping := map[string]string{
+		"id":         "id",
+		"resource":   "resource",
+		"resourceId": "resource_id",
+		"key":        "name",
+		"operator":   "operator",
+		"threshold":  "threshold",
+		"enabled":    "enabled",
+		"createdAt":  "created_at",
+	}
+
+	proc := processor.New(processor.ProcessorConfig[AlertRule, AlertRuleCreateDTO, AlertRuleUpdateDTO, AlertRuleResponseDTO]{
+		DB:                 db,
+		CRUD:               alertCRUD,
+		Converter:          &AlertRuleConverter{},
+		PaginationLimit:    config.PaginationLimit,
+		PaginationMaxLimit: config.MaxPaginationLimit,
+		FieldMap:           fieldMapping,
+		AllowedFields:      []string{"id", "resource", "resourceId", "key", "operator", "threshold", "enabled", "createdAt"},
+	}).
+		WithCreateHook(hooks.CreateHook).
+		WithUpdateHook(hooks.UpdateHook).
+		WithGetAllHook(hooks.GetAllHook)
+
+	res := &AlertResource{
+		processor:    proc,
+		crud:         alertCRUD,
+		config:       config,
+		errorHandler: &processor.DefaultErrorHandler{},
+	}
+
+	router.Get("/metrics/alerts", res.authorize, res.GetAll)
+	router.Get("/metrics/alerts/:id", res.authorize, res.owned, res.GetByID)
+	router.Post("/metrics/alerts", res.authorize, res.Create)
+	router.Put("/metrics/alerts/:id", res.authorize, res.owned, res.Update)
+	router.Delete("/metrics/alerts/:id", res.authorize, res.owned, res.Delete)
+}
+
+// authorize guards every alert route with OperationManageAlerts.
+func (r *AlertResource) authorize(c fiber.Ctx) error {
+	if err := r.config.authorize(c, AuthorizationRequest{Operation: OperationManageAlerts}); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+	return c.Next()
+}
+
+// owned reports rules of another tenant than the caller's as not found.
+func (r *AlertResource) owned(c fiber.Ctx) error {
+	tenant, err := r.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	rule, err := r.crud.GetByID(auth.Context(c), c.Params("id"))
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+	if rule.Tenant != tenant {
+		return r.errorHandler.HandleError(c, fiber.NewError(404, "alert rule not found"), "getById")
+	}
+	return c.Next()
+}
+
+func (r *AlertResource) GetAll(c fiber.Ctx) error {
+	return r.processor.GetAll(c)
+}
+
+func (r *AlertResource) GetByID(c fiber.Ctx) error {
+	return r.processor.GetByID(c)
+}
+
+func (r *AlertResource) Create(c fiber.Ctx) error {
+	return r.processor.Create(c)
+}
+
+func (r *AlertResource) Update(c fiber.Ctx) error {
+	return r.processor.Update(c)
+}
+
+func (r *AlertResource) Delete(c fiber.Ctx) error {
+	return r.processor.Delete(c)
+}
+
+// alertEvaluatorOptions tunes webhook delivery. Zero values fall back to the
+// package defaults.
+type alertEvaluatorOptions struct {
+	queueSize    int
+	maxAttempts  int
+	retryBackoff time.Duration
+	timeout      time.Duration
+	client       *http.Client
+	now          func() time.Time
+}
+
+type alertDelivery struct {
+	rule  AlertRule
+	event AlertEvent
+}
+
+// alertEvaluator checks every flushed batch against the enabled alert rules
+// and delivers the resulting webhooks from a background goroutine, so a slow
+// receiver never holds up the batch writer.
+type alertEvaluator struct {
+	db           database.Database
+	client       *http.Client
+	maxAttempts  int
+	retryBackoff time.Duration
+	timeout      time.Duration
+	now          func() time.Time
+
+	queue  chan alertDelivery
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// states remembers, per rule and metric, whether the condition held at
+	// the last flush and when the rule last fired for it. A forgotten metric
+	// counts as not holding, so it fires again if it still does.
+	mu     sync.Mutex
+	states *lruCache[alertKey, alertState]
+	closed bool
+}
+
+type alertKey struct{ rule, tenant, resource, resourceID string }
+
+type alertState struct {
+	holds     bool
+	lastFired time.Time
+}
+
+func newAlertEvaluator(db database.Database, opts alertEvaluatorOptions) *alertEvaluator {
+	if opts.queueSize <= 0 {
+		opts.queueSize = defaultAlertQueueSize
+	}
+	if opts.maxAttempts <= 0 {
+		opts.maxAttempts = defaultAlertMaxAttempts
+	}
+	if opts.retryBackoff <= 0 {
+		opts.retryBackoff = defaultAlertRetryBackoff
+	}
+	if opts.timeout <= 0 {
+		opts.timeout = defaultAlertTimeout
+	}
+	if opts.client == nil {
+		opts.client = &http.Client{Timeout: opts.timeout}
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &alertEvaluator{
+		db:           db,
+		client:       opts.client,
+		maxAttempts:  opts.maxAttempts,
+		retryBackoff: opts.retryBackoff,
+		timeout:      opts.timeout,
+		now:          opts.now,
+		queue:        make(chan alertDelivery, opts.queueSize),
+		ctx:          ctx,
+		cancel:       cancel,
+		states:       newLRUCache[alertKey, alertState](defaultAlertStateSize),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+
+	return e
+}
+
+// evaluate is a batchWriter flush listener.
+func (e *alertEvaluator) evaluate(batch []Metric) {
+	ctx, cancel := context.WithTimeout(e.ctx, e.timeout)
+	defer cancel()
+
+	rules, err := e.loadRules(ctx, batch)
+	if err != nil {
+		logger.Log.Error("metrics: failed to load alert rules", "error", err)
+		return
+	}
+
+	for _, rule := range rules {
+		for _, m := range batch {
+			if rule.watches(m) {
+				e.observe(rule, m)
+			}
+		}
+	}
+}
+
+func (e *alertEvaluator) loadRules(ctx context.Context, batch []Metric) ([]AlertRule, error) {
+	seenResources := make(map[string]bool)
+	seenTenants := make(map[string]bool)
+	resources := make([]any, 0, 1)
+	tenants := make([]any, 0, 1)
+	for _, m := range batch {
+		if !seenResources[m.Resource] {
+			seenResources[m.Resource] = true
+			resources = append(resources, m.Resource)
+		}
+		if !seenTenants[m.Tenant] {
+			seenTenants[m.Tenant] = true
+			tenants = append(tenants, m.Tenant)
+		}
+	}
+
+	sqlStr, args, err := query.New(e.db.Dialect()).
+		Select("id", "tenant", "resource", "resource_id", "name", "operator", "threshold", "webhook_url", "secret", "cooldown_seconds").
+		From(AlertRule{}.TableName()).
+		Where(query.Eq("enabled", true)).
+		Where(query.In("tenant", tenants...)).
+		Where(query.In("resource", resources...)).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := e.db.Query(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []AlertRule
+	for rows.Next() {
+		var r AlertRule
+		if err := rows.Scan(&r.Id, &r.Tenant, &r.Resource, &r.ResourceId, &r.Key, &r.Operator, &r.Threshold, &r.WebhookURL, &r.Secret, &r.CooldownSeconds); err != nil {
+			return nil, err
+		}
+		r.Enabled = true
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// observe records the value of m for rule and queues a webhook when it
+// crosses the threshold: the condition holds now but did not at the previous
+// flush. Crossings within the cooldown of the last webhook for the same
+// metric are ignored.
+func (e *alertEvaluator) observe(rule AlertRule, m Metric) {
+	now := e.now()
+	key := alertKey{rule.Id, m.Tenant, m.Resource, m.ResourceId}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	state, known := e.states.get(key)
+	if !rule.holds(m.Value) {
+		if known && state.holds {
+			state.holds = false
+			e.states.set(key, state)
+		}
+		return
+	}
+	if known && state.holds {
+		return
+	}
+
+	cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+	if known && now.Sub(state.lastFired) < cooldown {
+		state.holds = true
+		e.states.set(key, state)
+		return
+	}
+
+	delivery := alertDelivery{
+		rule: rule,
+		event: AlertEvent{
+			RuleID:     rule.Id,
+			Tenant:     m.Tenant,
+			Resource:   m.Resource,
+			ResourceID: m.ResourceId,
+			Key:        m.Key,
+			Operator:   rule.Operator,
+			Threshold:  rule.Threshold,
+			Value:      m.Value,
+			MetricID:   m.Id,
+			FiredAt:    now.UTC(),
+		},
+	}
+
+	select {
+	case e.queue <- delivery:
+		e.states.set(key, alertState{holds: true, lastFired: now})
+	default:
+		// Left as not holding, so the next flush tries again.
+		logger.Log.Error("metrics: alert queue full, dropping webhook", "rule", rule.Id)
+	}
+}
+
+func (e *alertEvaluator) run() {
+	defer e.wg.Done()
+
+	for d := range e.queue {
+		e.deliver(d)
+	}
+}
+
+// deliver POSTs the event, retrying with exponential backoff on transport
+// errors, 429 and 5xx responses.
+func (e *alertEvaluator) deliver(d alertDelivery) {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		logger.Log.Error("metrics: failed to encode alert", "error", err, "rule", d.rule.Id)
+		return
+	}
+
+	backoff := e.retryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := e.post(d.rule, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= e.maxAttempts {
+			logger.Log.Error("metrics: alert webhook failed",
+				"error", err,
+				"rule", d.rule.Id,
+				"attempts", attempt,
+			)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *alertEvaluator) post(rule AlertRule, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(e.ctx, e.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(e.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(AlertTimestampHeader, timestamp)
+	req.Header.Set(AlertSignatureHeader, SignAlertPayload(rule.Secret, timestamp, body))
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded %s", resp.Status)
+}
+
+// shutdown stops accepting alerts and waits for queued webhooks to be
+// delivered; pending retries are abandoned once ctx expires.
+func (e *alertEvaluator) shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.queue)
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		e.cancel()
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,428 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func insertAlertRule(t *testing.T, db database.Database, rule AlertRule) AlertRule {
+	t.Helper()
+
+	if rule.Id == "" {
+		rule.Id = uuid.New().String()
+	}
+	_, err := db.Exec(context.Background(), rebind(db,
+		`INSERT INTO metric_alerts (id, tenant, resource, resource_id, name, operator, threshold, webhook_url, secret, cooldown_seconds, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		rule.Id, rule.Tenant, rule.Resource, rule.ResourceId, rule.Key, rule.Operator, rule.Threshold,
+		rule.WebhookURL, rule.Secret, rule.CooldownSeconds, rule.Enabled)
+	require.NoError(t, err)
+	return rule
+}
+
+func doJSON(t *testing.T, app *fiber.App, method, target string, payload any) (int, []byte) {
+	t.Helper()
+
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		require.NoError(t, err)
+		body = bytes.NewReader(raw)
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, out
+}
+
+func TestAlertRule_Matches(t *testing.T) {
+	resourceID := uuid.New().String()
+	metric := Metric{Resource: "post", ResourceId: resourceID, Key: "views", Value: 10}
+
+	tests := []struct {
+		name string
+		rule AlertRule
+		want bool
+	}{
+		{name: "gt crossed", rule: AlertRule{Resource: "post", Key: "views", Operator: AlertOperatorGT, Threshold: 9}, want: true},
+		{name: "gt not crossed", rule: AlertRule{Resource: "post", Key: "views", Operator: AlertOperatorGT, Threshold: 10}, want: false},
+		{name: "gte", rule: AlertRule{Resource: "post", Key: "views", Operator: AlertOperatorGTE, Threshold: 10}, want: true},
+		{name: "lt", rule: AlertRule{Resource: "post", Key: "views", Operator: AlertOperatorLT, Threshold: 10}, want: false},
+		{name: "lte", rule: AlertRule{Resource: "post", Key: "views", Operator: AlertOperatorLTE, Threshold: 10}, want: true},
+		{name: "eq", rule: AlertRule{Resource: "post", Key: "views", Operator: AlertOperatorEQ, Threshold: 10}, want: true},
+		{name: "other key", rule: AlertRule{Resource: "post", Key: "likes", Operator: AlertOperatorGTE, Threshold: 0}, want: false},
+		{name: "specific resource", rule: AlertRule{Resource: "post", ResourceId: resourceID, Key: "views", Operator: AlertOperatorGTE, Threshold: 0}, want: true},
+		{name: "other resource", rule: AlertRule{Resource: "post", ResourceId: uuid.New().String(), Key: "views", Operator: AlertOperatorGTE, Threshold: 0}, want: false},
+		{name: "other tenant", rule: AlertRule{Tenant: "acme", Resource: "post", Key: "views", Operator: AlertOperatorGTE, Threshold: 0}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rule.matches(metric))
+		})
+	}
+}
+
+func TestAlertResource_CRUD(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterAlertRoutes(app, db, &config)
+
+	rule := map[string]any{
+		"resource":   "post",
+		"key":        " views ",
+		"operator":   "gte",
+		"threshold":  100,
+		"webhookUrl": "https://hooks.example.com/metrics",
+		"secret":     "s3cret",
+	}
+
+	status, body := doJSON(t, app, http.MethodPost, "/metrics/alerts", rule)
+	require.Equal(t, http.StatusCreated, status, string(body))
+	assert.NotContains(t, string(body), "s3cret")
+
+	var created AlertRuleResponseDTO
+	require.NoError(t, json.Unmarshal(body, &created))
+	assert.Equal(t, "views", created.Key)
+	assert.Equal(t, defaultAlertCooldownSeconds, created.CooldownSeconds)
+	assert.True(t, created.Enabled)
+
+	rule["threshold"] = 500
+	rule["enabled"] = false
+	status, body = doJSON(t, app, http.MethodPut, "/metrics/alerts/"+created.ID, rule)
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	status, body = doJSON(t, app, http.MethodGet, "/metrics/alerts/"+created.ID, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var updated AlertRuleResponseDTO
+	require.NoError(t, json.Unmarshal(body, &updated))
+	assert.Equal(t, 500, updated.Threshold)
+	assert.False(t, updated.Enabled)
+
+	status, _ = doJSON(t, app, http.MethodDelete, "/metrics/alerts/"+created.ID, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Equal(t, 0, countRows(t, db, "metric_alerts"))
+}
+
+func TestAlertResource_TenantIsolation(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	config.TenantHeader = testTenantHeader
+	require.NoError(t, config.Validate())
+
+	newApp := func(tenant string) *fiber.App {
+		app := fiber.New()
+		app.Use(withRoles(defaultAdminRole), asTenant(tenant))
+		RegisterAlertRoutes(app, db, &config)
+		return app
+	}
+	acme, globex := newApp("acme"), newApp("globex")
+
+	rule := map[string]any{
+		"resource":   "post",
+		"key":        "views",
+		"operator":   "gte",
+		"threshold":  100,
+		"webhookUrl": "https://hooks.example.com/metrics",
+		"secret":     "s3cret",
+	}
+	status, body := doJSON(t, acme, http.MethodPost, "/metrics/alerts", rule)
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var created AlertRuleResponseDTO
+	require.NoError(t, json.Unmarshal(body, &created))
+
+	var tenant string
+	require.NoError(t, db.QueryRow(context.Background(),
+		rebind(db, "SELECT tenant FROM metric_alerts WHERE id = ?"), created.ID).Scan(&tenant))
+	assert.Equal(t, "acme", tenant)
+
+	status, body = doJSON(t, globex, http.MethodGet, "/metrics/alerts", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.NotContains(t, string(body), created.ID)
+
+	status, body = doJSON(t, acme, http.MethodGet, "/metrics/alerts", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), created.ID)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		status, body = doJSON(t, globex, method, "/metrics/alerts/"+created.ID, rule)
+		assert.Equal(t, http.StatusNotFound, status, "%s: %s", method, body)
+	}
+	assert.Equal(t, 1, countRows(t, db, "metric_alerts"))
+
+	status, body = doJSON(t, acme, http.MethodGet, "/metrics/alerts/"+created.ID, nil)
+	assert.Equal(t, http.StatusOK, status, string(body))
+}
+
+func TestAlertResource_Validation(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterAlertRoutes(app, db, &config)
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"resource":   "post",
+			"key":        "views",
+			"operator":   "gt",
+			"threshold":  1,
+			"webhookUrl": "https://hooks.example.com/metrics",
+			"secret":     "s3cret",
+		}
+	}
+
+	tests := []struct {
+		name  string
+		field string
+		value any
+	}{
+		{name: "disallowed resource", field: "resource", value: "comment"},
+		{name: "invalid resource id", field: "resourceId", value: "42"},
+		{name: "empty key", field: "key", value: " "},
+		{name: "unknown operator", field: "operator", value: ">"},
+		{name: "relative webhook", field: "webhookUrl", value: "/hook"},
+		{name: "non http webhook", field: "webhookUrl", value: "ftp://example.com"},
+		{name: "empty secret", field: "secret", value: ""},
+		{name: "negative cooldown", field: "cooldownSeconds", value: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid()
+			rule[tt.field] = tt.value
+			status, _ := doJSON(t, app, http.MethodPost, "/metrics/alerts", rule)
+			assert.Equal(t, http.StatusBadRequest, status)
+		})
+	}
+	assert.Equal(t, 0, countRows(t, db, "metric_alerts"))
+}
+
+type webhookRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newWebhookRecorder(t *testing.T, statuses ...int) (*webhookRecorder, *httptest.Server) {
+	rec := &webhookRecorder{statuses: statuses, received: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rec.mu.Lock()
+		status := http.StatusOK
+		if len(rec.requests) < len(rec.statuses) {
+			status = rec.statuses[len(rec.requests)]
+		}
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		rec.mu.Unlock()
+
+		w.WriteHeader(status)
+		rec.received <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+func (r *webhookRecorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %d webhook calls, got %d", n, i)
+		}
+	}
+}
+
+func TestAlertEvaluator_SendsSignedWebhook(t *testing.T) {
+	db := newTestDB(t)
+	rec, srv := newWebhookRecorder(t)
+	rule := insertAlertRule(t, db, AlertRule{
+		Resource: "post", Key: "views", Operator: AlertOperatorGTE, Threshold: 100,
+		WebhookURL: srv.URL, Secret: "s3cret", CooldownSeconds: 60, Enabled: true,
+	})
+	insertAlertRule(t, db, AlertRule{
+		Resource: "post", Key: "views", Operator: AlertOperatorGTE, Threshold: 0,
+		WebhookURL: srv.URL, Secret: "s3cret", CooldownSeconds: 60, Enabled: false,
+	})
+
+	e := newAlertEvaluator(db, alertEvaluatorOptions{})
+
+	below := sampleMetric()
+	below.Key, below.Value = "views", 99
+	above := sampleMetric()
+	above.Key, above.Value = "views", 150
+	e.evaluate([]Metric{below, above})
+
+	rec.wait(t, 1)
+	require.NoError(t, e.shutdown(context.Background()))
+	require.Len(t, rec.requests, 1)
+
+	req := rec.requests[0]
+	timestamp := req.Header.Get(AlertTimestampHeader)
+	assert.Equal(t, SignAlertPayload("s3cret", timestamp, rec.bodies[0]), req.Header.Get(AlertSignatureHeader))
+
+	var event AlertEvent
+	require.NoError(t, json.Unmarshal(rec.bodies[0], &event))
+	assert.Equal(t, rule.Id, event.RuleID)
+	assert.Equal(t, above.Id, event.MetricID)
+	assert.Equal(t, 150, event.Value)
+}
+
+func TestAlertEvaluator_OnlyFiresForItsTenant(t *testing.T) {
+	db := newTestDB(t)
+	rec, srv := newWebhookRecorder(t)
+	rule := insertAlertRule(t, db, AlertRule{
+		Tenant: "acme", Resource: "post", Key: "views", Operator: AlertOperatorGT, Threshold: 0,
+		WebhookURL: srv.URL, Secret: "s3cret", CooldownSeconds: 60, Enabled: true,
+	})
+
+	e := newAlertEvaluator(db, alertEvaluatorOptions{})
+
+	globex := sampleMetric()
+	globex.Tenant, globex.Key = "globex", "views"
+	acme := sampleMetric()
+	acme.Tenant, acme.Key = "acme", "views"
+	e.evaluate([]Metric{globex})
+	e.evaluate([]Metric{acme})
+
+	rec.wait(t, 1)
+	require.NoError(t, e.shutdown(context.Background()))
+	require.Len(t, rec.requests, 1)
+
+	var event AlertEvent
+	require.NoError(t, json.Unmarshal(rec.bodies[0], &event))
+	assert.Equal(t, rule.Id, event.RuleID)
+	assert.Equal(t, "acme", event.Tenant)
+	assert.Equal(t, acme.Id, event.MetricID)
+}
+
+func TestAlertEvaluator_FiresOnCrossing(t *testing.T) {
+	db := newTestDB(t)
+	rec, srv := newWebhookRecorder(t)
+	insertAlertRule(t, db, AlertRule{
+		Resource: "post", Key: "views", Operator: AlertOperatorGTE, Threshold: 100,
+		WebhookURL: srv.URL, Secret: "s3cret", CooldownSeconds: 60, Enabled: true,
+	})
+
+	var mu sync.Mutex
+	now := time.Now()
+	e := newAlertEvaluator(db, alertEvaluatorOptions{now: func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}})
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	m := sampleMetric()
+	m.Key = "views"
+	at := func(value int) []Metric {
+		m.Value = value
+		return []Metric{m}
+	}
+
+	e.evaluate(at(50))
+	e.evaluate(at(150)) // crosses: fires
+	e.evaluate(at(200)) // stays above
+	rec.wait(t, 1)
+
+	e.evaluate(at(50))
+	e.evaluate(at(150)) // crosses again within the cooldown
+	advance(61 * time.Second)
+	e.evaluate(at(160)) // still above since the suppressed crossing
+	e.evaluate(at(50))
+	e.evaluate(at(150)) // crosses after the cooldown: fires
+	rec.wait(t, 1)
+
+	require.NoError(t, e.shutdown(context.Background()))
+	require.Len(t, rec.requests, 2)
+	var event AlertEvent
+	require.NoError(t, json.Unmarshal(rec.bodies[1], &event))
+	assert.Equal(t, 150, event.Value)
+}
+
+func TestAlertEvaluator_TracksEachResource(t *testing.T) {
+	db := newTestDB(t)
+	rec, srv := newWebhookRecorder(t)
+	insertAlertRule(t, db, AlertRule{
+		Resource: "post", Key: "views", Operator: AlertOperatorGT, Threshold: 0,
+		WebhookURL: srv.URL, Secret: "s3cret", CooldownSeconds: 60, Enabled: true,
+	})
+
+	e := newAlertEvaluator(db, alertEvaluatorOptions{})
+
+	first := sampleMetric()
+	first.Key = "views"
+	second := sampleMetric()
+	second.Key = "views"
+	e.evaluate([]Metric{first, second})
+	third := sampleMetric()
+	third.Key = "views"
+	e.evaluate([]Metric{third, first})
+
+	rec.wait(t, 3)
+	require.NoError(t, e.shutdown(context.Background()))
+	require.Len(t, rec.requests, 3, "a wildcard rule fires once for each resource crossing it")
+
+	var fired []string
+	for _, body := range rec.bodies {
+		var event AlertEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		fired = append(fired, event.ResourceID)
+	}
+	assert.ElementsMatch(t, []string{first.ResourceId, second.ResourceId, third.ResourceId}, fired)
+}
+
+func TestAlertEvaluator_RetriesFailedDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	rec, srv := newWebhookRecorder(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	insertAlertRule(t, db, AlertRule{
+		Resource: "post", Key: "views", Operator: AlertOperatorGT, Threshold: 0,
+		WebhookURL: srv.URL, Secret: "s3cret", CooldownSeconds: 60, Enabled: true,
+	})
+	insertAlertRule(t, db, AlertRule{
+		Resource: "post", Key: "views", Operator: AlertOperatorGT, Threshold: 0,
+		WebhookURL: srv.URL + "/other", Secret: "s3cret", CooldownSeconds: 60, Enabled: true,
+	})
+
+	e := newAlertEvaluator(db, alertEvaluatorOptions{retryBackoff: time.Millisecond})
+
+	m := sampleMetric()
+	m.Key = "views"
+	e.evaluate([]Metric{m})
+
+	// The first rule needs three attempts; the second is delivered at once.
+	rec.wait(t, 4)
+	require.NoError(t, e.shutdown(context.Background()))
+	assert.Len(t, rec.requests, 4)
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/auth"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/pagination"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+var auditColumns = []string{
+	"id", "tenant", "metric_id", "resource", "resource_id", "name",
+	"operation", "actor", "old_value", "new_value", "created_at",
+}
+
+// newAuditEntry describes op on m by the caller of c. after is nil for
+// deletes.
+func newAuditEntry(c fiber.Ctx, op string, m Metric, after *int) AuditEntry {
+	actor := ""
+	if user := auth.GetAuthenticatedUser(c); user != nil {
+		actor = user.UserID
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return AuditEntry{
+		Id:         uuid.New().String(),
+		Tenant:     m.Tenant,
+		MetricId:   m.Id,
+		Resource:   m.Resource,
+		ResourceId: m.ResourceId,
+		Key:        m.Key,
+		Operation:  op,
+		Actor:      actor,
+		OldValue:   m.Value,
+		NewValue:   after,
+		CreatedAt:  &now,
+	}
+}
+
+// execAudited runs sqlStr, the change of entry's metric, and records entry in
+// the same transaction, so no change goes unaudited. When sqlStr changes no
+// row, because the metric was written in the meantime, stale is returned and
+// nothing is recorded.
+func execAudited(ctx context.Context, db database.Database, entry AuditEntry, stale error, sqlStr string, args []any) (err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	res, err := tx.Exec(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	// Every change bumps the version, so even MySQL, which does not count
+	// rows left unchanged, reports the row as affected.
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return stale
+	}
+
+	insertSQL, insertArgs, err := query.New(db.Dialect()).
+		Insert(AuditEntry{}.TableName()).
+		Columns(auditColumns...).
+		Values(entry.Id, entry.Tenant, entry.MetricId, entry.Resource, entry.ResourceId, entry.Key,
+			entry.Operation, entry.Actor, entry.OldValue, entry.NewValue, *entry.CreatedAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, insertSQL, insertArgs...); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// fetchAudit returns a page of the audit trail of metric metricID in tenant,
+// newest first.
+func fetchAudit(ctx context.Context, db database.Database, tenant, metricID string, limit, offset int) ([]AuditEntry, error) {
+	sqlStr, args, err := query.New(db.Dialect()).
+		Select(auditColumns...).
+		From(AuditEntry{}.TableName()).
+		Where(query.Eq("tenant", tenant)).
+		Where(query.Eq("metric_id", metricID)).
+		OrderBy("created_at", query.DESC).
+		OrderBy("id", query.DESC).
+		Limit(limit).
+		Offset(offset).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.Id, &e.Tenant, &e.MetricId, &e.Resource, &e.ResourceId, &e.Key,
+			&e.Operation, &e.Actor, &e.OldValue, &e.NewValue, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func countAudit(ctx context.Context, db database.Database, tenant, metricID string) (int, error) {
+	sqlStr, args, err := query.New(db.Dialect()).
+		Select().
+		SelectExpr(query.Count(query.RawExpr("*"))).
+		From(AuditEntry{}.TableName()).
+		Where(query.Eq("tenant", tenant)).
+		Where(query.Eq("metric_id", metricID)).
+		Build()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := db.QueryRow(ctx, sqlStr, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Audit answers GET /metrics/:id/audit with the updates and deletes of a
+// metric, newest first, paginated like GET /metrics. The trail stays readable
+// once the metric is deleted.
+func (r *MetricResource) Audit(c fiber.Ctx) error {
+	id := c.Params("id")
+	ctx := auth.Context(c)
+
+	tenant, err := r.hooks.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	latest, err := fetchAudit(ctx, r.db, tenant, id, 1, 0)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getAll")
+	}
+	var target Metric
+	if len(latest) > 0 {
+		e := latest[0]
+		target = Metric{Id: id, Tenant: e.Tenant, Resource: e.Resource, ResourceId: e.ResourceId, Key: e.Key}
+	} else {
+		existing, err := r.load(c, id)
+		if err != nil {
+			return r.errorHandler.HandleError(c, err, "getById")
+		}
+		target = *existing
+	}
+
+	if err := r.authorize(c, OperationReadAudit, target); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+	if !r.hooks.config.canRead(c, target) {
+		return r.errorHandler.HandleError(c, fiber.NewError(404, "metric not found"), "getById")
+	}
+
+	config := r.hooks.config
+	limit := pagination.ParseIntQuery(c, "limit", config.PaginationLimit, config.MaxPaginationLimit)
+	page := pagination.ParseIntQuery(c, "page", 1, 10000)
+	if page < 1 {
+		page = 1
+	}
+
+	total, err := countAudit(ctx, r.db, tenant, id)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getAll")
+	}
+	entries, err := fetchAudit(ctx, r.db, tenant, id, limit, (page-1)*limit)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getAll")
+	}
+
+	converter := &AuditEntryConverter{}
+	return pagination.SendHydraCollection(c, converter.ModelsToResponseDTOs(entries), &total, limit, page, config.PaginationLimit)
+}
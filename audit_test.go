@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditCollection struct {
+	TotalItems int                     `json:"hydra:totalItems"`
+	Member     []AuditEntryResponseDTO `json:"hydra:member"`
+}
+
+func getAudit(t *testing.T, app *fiber.App, target string) auditCollection {
+	t.Helper()
+
+	status, body := doJSON(t, app, http.MethodGet, target, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var out auditCollection
+	require.NoError(t, json.Unmarshal(body, &out))
+	return out
+}
+
+func TestMetricResource_Audit(t *testing.T) {
+	db := newTestDB(t)
+	m := seedMetric(t, db, "score", 10)
+	config := DefaultConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(context.Background()) })
+
+	admin := fiber.New()
+	admin.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(admin, db, &config, writer, nil, nil, nil, nil)
+	user := fiber.New()
+	user.Use(withRoles("user"))
+	RegisterMetricRoutes(user, db, &config, writer, nil, nil, nil, nil)
+
+	audit := getAudit(t, admin, "/metrics/"+m.Id+"/audit")
+	assert.Zero(t, audit.TotalItems)
+	assert.Empty(t, audit.Member)
+
+	// Entries are ordered by timestamp; keep them apart.
+	for _, value := range []int{20, 30} {
+		status, body := doJSON(t, admin, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: value})
+		require.Equal(t, http.StatusOK, status, string(body))
+		time.Sleep(2 * time.Millisecond)
+	}
+	status, body := doJSON(t, user, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 0})
+	require.Equal(t, http.StatusForbidden, status, string(body))
+	status, body = doJSON(t, admin, http.MethodDelete, "/metrics/"+m.Id, nil)
+	require.Equal(t, http.StatusNoContent, status, string(body))
+
+	audit = getAudit(t, admin, "/metrics/"+m.Id+"/audit")
+	assert.Equal(t, 3, audit.TotalItems, "denied writes are not audited")
+	require.Len(t, audit.Member, 3)
+
+	deleted, second, first := audit.Member[0], audit.Member[1], audit.Member[2]
+	assert.Equal(t, OperationDelete, deleted.Operation)
+	assert.Equal(t, 30, deleted.OldValue)
+	assert.Nil(t, deleted.NewValue)
+	assert.Equal(t, OperationUpdate, second.Operation)
+	assert.Equal(t, 20, second.OldValue)
+	require.NotNil(t, second.NewValue)
+	assert.Equal(t, 30, *second.NewValue)
+	assert.Equal(t, 10, first.OldValue)
+	require.NotNil(t, first.NewValue)
+	assert.Equal(t, 20, *first.NewValue)
+
+	for _, e := range audit.Member {
+		assert.Equal(t, m.Id, e.MetricID)
+		assert.Equal(t, "score", e.Key)
+		assert.Equal(t, "user-1", e.Actor)
+		assert.NotNil(t, e.CreatedAt)
+	}
+
+	page := getAudit(t, admin, "/metrics/"+m.Id+"/audit?limit=1&page=2")
+	assert.Equal(t, 3, page.TotalItems)
+	require.Len(t, page.Member, 1)
+	assert.Equal(t, second.ID, page.Member[0].ID)
+
+	status, body = doJSON(t, user, http.MethodGet, "/metrics/"+m.Id+"/audit", nil)
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics/"+uuid.New().String()+"/audit", nil)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+}
+
+func TestMetricResource_AuditIsolatesTenants(t *testing.T) {
+	rec, seed := newTestRecorder(t, DefaultConfig())
+	db := rec.db
+	ctx := context.Background()
+	require.NoError(t, rec.WithTenant("acme").Set("post", uuid.New().String(), "score", 1))
+	require.NoError(t, seed.shutdown(ctx))
+
+	config := DefaultConfig()
+	config.TenantHeader = testTenantHeader
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(ctx) })
+
+	newApp := func(tenant string) *fiber.App {
+		app := fiber.New()
+		app.Use(withRoles(defaultAdminRole), asTenant(tenant))
+		RegisterMetricRoutes(app, db, &config, writer, nil, nil, nil, nil)
+		return app
+	}
+	acme, globex := newApp("acme"), newApp("globex")
+
+	var id string
+	require.NoError(t, db.QueryRow(ctx, rebind(db, "SELECT id FROM metrics WHERE tenant = ?"), "acme").Scan(&id))
+	status, body := doJSON(t, acme, http.MethodDelete, "/metrics/"+id, nil)
+	require.Equal(t, http.StatusNoContent, status, string(body))
+
+	audit := getAudit(t, acme, "/metrics/"+id+"/audit")
+	require.Len(t, audit.Member, 1)
+	assert.Equal(t, OperationDelete, audit.Member[0].Operation)
+
+	status, body = doJSON(t, globex, http.MethodGet, "/metrics/"+id+"/audit", nil)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
+	"github.com/nicolasbonnici/gorest/rbac"
+)
+
+// Operations checked by an Authorizer.
+const (
+	// OperationRead covers every read of metric values: listing, fetching,
+	// aggregates, unique counts and live streams.
+	OperationRead = "read"
+	// OperationReadPrivate is checked, on top of OperationRead, for every key
+	// whose key_visibility is private. Denied keys are left out of the
+	// response rather than failing it.
+	OperationReadPrivate = "read_private"
+	// OperationCreate covers POST /metrics, which records or increments a
+	// metric.
+	OperationCreate = "create"
+	// OperationUpdate covers overwriting a metric's value.
+	OperationUpdate = "update"
+	// OperationDelete covers deleting a metric.
+	OperationDelete = "delete"
+	// OperationRestore covers restoring a deleted metric.
+	OperationRestore = "restore"
+	// OperationReadDeleted covers listing deleted metrics with
+	// ?withDeleted=true.
+	OperationReadDeleted = "read_deleted"
+	// OperationReadAudit covers reading a metric's audit trail, which names
+	// who changed it.
+	OperationReadAudit = "read_audit"
+	// OperationManageAlerts covers every /metrics/alerts route. Rules carry
+	// webhook URLs, so even listing them is a write-side operation.
+	OperationManageAlerts = "manage_alerts"
+)
+
+const defaultAdminRole = "admin"
+
+// AuthorizationRequest describes what a caller is about to do. ResourceType,
+// ResourceID and Key are empty when the operation spans several of them, as
+// when listing metrics or opening a stream.
+type AuthorizationRequest struct {
+	Operation    string
+	ResourceType string
+	ResourceID   string
+	Key          string
+}
+
+// Authorizer decides whether the caller of c may perform req. A returned
+// *fiber.Error is answered with its status; any other error with 403.
+type Authorizer interface {
+	Authorize(c fiber.Ctx, req AuthorizationRequest) error
+}
+
+// AuthorizerFunc adapts a function to Authorizer.
+type AuthorizerFunc func(c fiber.Ctx, req AuthorizationRequest) error
+
+func (f AuthorizerFunc) Authorize(c fiber.Ctx, req AuthorizationRequest) error {
+	return f(c, req)
+}
+
+// RoleAuthorizer is the policy used when Config.Authorizer is not set: anyone
+// may read public metrics and create (increment) metrics, while private keys,
+// updates, deletes and alert rules require AdminRole, read from the roles the
+// gorest auth middleware stores on the request context.
+type RoleAuthorizer struct {
+	AdminRole string
+}
+
+func (a RoleAuthorizer) Authorize(c fiber.Ctx, req AuthorizationRequest) error {
+	switch req.Operation {
+	case OperationRead, OperationCreate:
+		return nil
+	}
+
+	if hasRole(c, a.AdminRole) {
+		return nil
+	}
+	if auth.GetAuthenticatedUser(c) == nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+	}
+	return fiber.NewError(fiber.StatusForbidden, "insufficient permissions")
+}
+
+func hasRole(c fiber.Ctx, role string) bool {
+	roles, _ := rbac.GetRoles(c.Context())
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks req against the configured Authorizer, falling back to a
+// RoleAuthorizer for AdminRole.
+func (c *Config) authorize(ctx fiber.Ctx, req AuthorizationRequest) error {
+	authorizer := c.Authorizer
+	if authorizer == nil {
+		authorizer = RoleAuthorizer{AdminRole: c.adminRole()}
+	}
+
+	err := authorizer.Authorize(ctx, req)
+	if err == nil {
+		return nil
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr
+	}
+	return fiber.NewError(fiber.StatusForbidden, err.Error())
+}
+
+// adminRole returns the role the default policy requires for write-side
+// operations; empty means "admin".
+func (c *Config) adminRole() string {
+	if c.AdminRole == "" {
+		return defaultAdminRole
+	}
+	return c.AdminRole
+}
@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest/auth/context"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withRoles authenticates every request as a user holding roles, as the gorest
+// auth middleware does.
+func withRoles(roles ...string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		c.SetContext(rbac.WithUser(c.Context(), "user-1", roles))
+		authcontext.SetUserID(c, "user-1")
+		return c.Next()
+	}
+}
+
+// seedMetric persists one post metric and returns it as stored.
+func seedMetric(t *testing.T, db database.Database, key string, value int) Metric {
+	t.Helper()
+
+	w := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	w.enqueue(Metric{Id: uuid.New().String(), Resource: "post", ResourceId: uuid.New().String(), Key: key, Value: value})
+	require.NoError(t, w.shutdown(context.Background()))
+
+	var m Metric
+	row := db.QueryRow(context.Background(), rebind(db, "SELECT id, resource, resource_id, name, value FROM metrics WHERE name = ?"), key)
+	require.NoError(t, row.Scan(&m.Id, &m.Resource, &m.ResourceId, &m.Key, &m.Value))
+	return m
+}
+
+func TestRoleAuthorizer(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		roles     []string
+		want      int
+	}{
+		{name: "anonymous read", operation: OperationRead, want: 0},
+		{name: "anonymous create", operation: OperationCreate, want: 0},
+		{name: "anonymous private read", operation: OperationReadPrivate, want: http.StatusUnauthorized},
+		{name: "user private read", operation: OperationReadPrivate, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "admin private read", operation: OperationReadPrivate, roles: []string{"admin"}, want: 0},
+		{name: "user audit read", operation: OperationReadAudit, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "admin audit read", operation: OperationReadAudit, roles: []string{"admin"}, want: 0},
+		{name: "user deleted read", operation: OperationReadDeleted, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "admin deleted read", operation: OperationReadDeleted, roles: []string{"admin"}, want: 0},
+		{name: "anonymous restore", operation: OperationRestore, want: http.StatusUnauthorized},
+		{name: "user restore", operation: OperationRestore, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "admin restore", operation: OperationRestore, roles: []string{"admin"}, want: 0},
+		{name: "anonymous update", operation: OperationUpdate, want: http.StatusUnauthorized},
+		{name: "anonymous delete", operation: OperationDelete, want: http.StatusUnauthorized},
+		{name: "anonymous alerts", operation: OperationManageAlerts, want: http.StatusUnauthorized},
+		{name: "user update", operation: OperationUpdate, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "user delete", operation: OperationDelete, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "user alerts", operation: OperationManageAlerts, roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "admin update", operation: OperationUpdate, roles: []string{"user", "admin"}, want: 0},
+		{name: "admin delete", operation: OperationDelete, roles: []string{"admin"}, want: 0},
+		{name: "admin alerts", operation: OperationManageAlerts, roles: []string{"admin"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			if tt.roles != nil {
+				app.Use(withRoles(tt.roles...))
+			}
+			var err error
+			app.Get("/", func(c fiber.Ctx) error {
+				err = RoleAuthorizer{AdminRole: "admin"}.Authorize(c, AuthorizationRequest{Operation: tt.operation})
+				return nil
+			})
+
+			resp, testErr := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			require.NoError(t, testErr)
+			resp.Body.Close()
+
+			if tt.want == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var fiberErr *fiber.Error
+			require.ErrorAs(t, err, &fiberErr)
+			assert.Equal(t, tt.want, fiberErr.Code)
+		})
+	}
+}
+
+func TestConfig_Authorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorizer Authorizer
+		adminRole  string
+		want       int
+	}{
+		{name: "default admin role", want: 0},
+		{name: "custom admin role", adminRole: "ops", want: http.StatusForbidden},
+		{
+			name: "plain error is forbidden",
+			authorizer: AuthorizerFunc(func(fiber.Ctx, AuthorizationRequest) error {
+				return errors.New("not your metric")
+			}),
+			want: http.StatusForbidden,
+		},
+		{
+			name: "fiber error keeps its status",
+			authorizer: AuthorizerFunc(func(fiber.Ctx, AuthorizationRequest) error {
+				return fiber.NewError(http.StatusNotFound, "metric not found")
+			}),
+			want: http.StatusNotFound,
+		},
+		{
+			name:       "custom authorizer allows",
+			authorizer: AuthorizerFunc(func(fiber.Ctx, AuthorizationRequest) error { return nil }),
+			want:       0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Authorizer = tt.authorizer
+			config.AdminRole = tt.adminRole
+
+			app := fiber.New()
+			app.Use(withRoles(defaultAdminRole))
+			var err error
+			app.Get("/", func(c fiber.Ctx) error {
+				err = config.authorize(c, AuthorizationRequest{Operation: OperationDelete})
+				return nil
+			})
+
+			resp, testErr := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			require.NoError(t, testErr)
+			resp.Body.Close()
+
+			if tt.want == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var fiberErr *fiber.Error
+			require.ErrorAs(t, err, &fiberErr)
+			assert.Equal(t, tt.want, fiberErr.Code)
+		})
+	}
+}
+
+func TestMetricResource_DefaultPolicy(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(context.Background()) })
+	m := seedMetric(t, db, "reputation", 10)
+
+	anonymous := fiber.New()
+	RegisterMetricRoutes(anonymous, db, &config, writer, nil, nil, nil, nil)
+	user := fiber.New()
+	user.Use(withRoles("user"))
+	RegisterMetricRoutes(user, db, &config, writer, nil, nil, nil, nil)
+	admin := fiber.New()
+	admin.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(admin, db, &config, writer, nil, nil, nil, nil)
+
+	status, body := doJSON(t, anonymous, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: uuid.New().String(), Key: "views", Value: 1,
+	})
+	assert.Equal(t, http.StatusCreated, status, string(body))
+
+	status, body = doJSON(t, anonymous, http.MethodGet, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	status, body = doJSON(t, anonymous, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 0})
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+	status, body = doJSON(t, user, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 0})
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+	status, body = doJSON(t, user, http.MethodDelete, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+
+	stored, err := fetchMetric(context.Background(), db, Metric{Resource: m.Resource, ResourceId: m.ResourceId, Key: m.Key})
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, 10, stored.Value, "denied writes leave the metric untouched")
+
+	status, body = doJSON(t, admin, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 0})
+	assert.Equal(t, http.StatusOK, status, string(body))
+	status, body = doJSON(t, admin, http.MethodDelete, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusNoContent, status, string(body))
+}
+
+func TestMetricResource_CustomAuthorizer(t *testing.T) {
+	db := newTestDB(t)
+	m := seedMetric(t, db, "revenue", 10)
+
+	var requests []AuthorizationRequest
+	config := DefaultConfig()
+	config.Authorizer = AuthorizerFunc(func(_ fiber.Ctx, req AuthorizationRequest) error {
+		requests = append(requests, req)
+		if req.Key == "revenue" && req.Operation != OperationUpdate {
+			return fiber.NewError(http.StatusForbidden, "revenue is private")
+		}
+		return nil
+	})
+
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(context.Background()) })
+	app := fiber.New()
+	RegisterRoutes(app, db, &config, writer, newMetricHub(0), nil, nil, nil, nil)
+
+	status, body := doJSON(t, app, http.MethodGet, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+	assert.Contains(t, string(body), "revenue is private")
+
+	status, body = doJSON(t, app, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 20})
+	require.Equal(t, http.StatusOK, status, string(body))
+	var updated MetricResponseDTO
+	require.NoError(t, json.Unmarshal(body, &updated))
+	assert.Equal(t, 20, updated.Value)
+
+	status, body = doJSON(t, app, http.MethodGet, "/metrics/resources/post/"+m.ResourceId+"?keys=revenue", nil)
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+
+	status, body = doJSON(t, app, http.MethodGet, "/metrics/alerts", nil)
+	assert.Equal(t, http.StatusOK, status, string(body), "a custom authorizer replaces the default policy")
+
+	require.Len(t, requests, 4)
+	assert.Equal(t, AuthorizationRequest{Operation: OperationRead, ResourceType: "post", ResourceID: m.ResourceId, Key: "revenue"}, requests[0])
+	assert.Equal(t, AuthorizationRequest{Operation: OperationUpdate, ResourceType: "post", ResourceID: m.ResourceId, Key: "revenue"}, requests[1])
+	assert.Equal(t, AuthorizationRequest{Operation: OperationRead, ResourceType: "post", ResourceID: m.ResourceId, Key: "revenue"}, requests[2])
+	assert.Equal(t, AuthorizationRequest{Operation: OperationManageAlerts}, requests[3])
+}
+
+func TestAlertResource_RequiresAdmin(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	app := fiber.New()
+	RegisterAlertRoutes(app, db, &config)
+
+	status, body := doJSON(t, app, http.MethodGet, "/metrics/alerts", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+
+	status, body = doJSON(t, app, http.MethodPost, "/metrics/alerts", map[string]any{
+		"resource":   "post",
+		"key":        "views",
+		"operator":   "gte",
+		"threshold":  100,
+		"webhookUrl": "https://hooks.example.com/metrics",
+		"secret":     "s3cret",
+	})
+	assert.Equal(t, http.StatusUnauthorized, status, string(body))
+	assert.Equal(t, 0, countRows(t, db, "metric_alerts"))
+}
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/hooks"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// Actions applied to a resource's metrics when the resource itself is deleted.
+const (
+	OnResourceDeleteDelete  = "delete"
+	OnResourceDeleteArchive = "archive"
+)
+
+const archiveTableName = "metrics_archive"
+
+var (
+	errNoDatabase = errors.New("metrics: plugin has no database")
+	errNoTenant   = errors.New("metrics: no tenant on context, mount TenantMiddleware or use WithTenant")
+)
+
+// DeleteForResource permanently removes every metric the tenant recorded
+// against the given resource, along with their unique visitor sketches and
+// reached milestones.
+func (p *MetricsPlugin) DeleteForResource(ctx context.Context, tenant, resourceType, resourceID string) error {
+	if p.db == nil {
+		return errNoDatabase
+	}
+	return deleteResourceMetrics(ctx, p.db, tenant, resourceType, resourceID)
+}
+
+// ArchiveForResource moves every metric the tenant recorded against the given
+// resource to the metrics_archive table, so it no longer shows up in the API
+// but can still be recovered. Their sketches and milestones are removed.
+func (p *MetricsPlugin) ArchiveForResource(ctx context.Context, tenant, resourceType, resourceID string) error {
+	if p.db == nil {
+		return errNoDatabase
+	}
+	return archiveResourceMetrics(ctx, p.db, tenant, resourceType, resourceID)
+}
+
+// CleanupForResource applies the configured on_resource_delete action to the
+// metrics the tenant recorded against a deleted resource.
+func (p *MetricsPlugin) CleanupForResource(ctx context.Context, tenant, resourceType, resourceID string) error {
+	if p.config.OnResourceDelete == OnResourceDeleteArchive {
+		return p.ArchiveForResource(ctx, tenant, resourceType, resourceID)
+	}
+	return p.DeleteForResource(ctx, tenant, resourceType, resourceID)
+}
+
+// resourceCondition selects the metrics of one tenant's resource.
+func resourceCondition(tenant, resourceType, resourceID string) query.Condition {
+	return query.And(
+		query.Eq("tenant", tenant),
+		query.Eq("resource", resourceType),
+		query.Eq("resource_id", resourceID),
+	)
+}
+
+// metricCondition selects the metric of m's tenant and tuple.
+func metricCondition(m Metric) query.Condition {
+	return query.And(
+		query.Eq("tenant", m.Tenant),
+		query.Eq("resource", m.Resource),
+		query.Eq("resource_id", m.ResourceId),
+		query.Eq("name", m.Key),
+	)
+}
+
+// resourceTables lists the tables holding rows of a resource's metrics, which
+// are removed together with the metrics.
+var resourceTables = []string{Metric{}.TableName(), sketchesTableName, milestonesTableName}
+
+func deleteResourceMetrics(ctx context.Context, db database.Database, tenant, resourceType, resourceID string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := deleteResourceRows(ctx, db, tx, tenant, resourceType, resourceID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// deleteResourceRows removes the rows of the resource from every
+// resourceTables table within tx.
+func deleteResourceRows(ctx context.Context, db database.Database, tx database.Tx, tenant, resourceType, resourceID string) error {
+	for _, table := range resourceTables {
+		sqlStr, args, err := query.New(db.Dialect()).
+			Delete(table).
+			Where(resourceCondition(tenant, resourceType, resourceID)).
+			Build()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, sqlStr, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func archiveResourceMetrics(ctx context.Context, db database.Database, tenant, resourceType, resourceID string) error {
+	dialect := db.Dialect()
+	where, args, _ := resourceCondition(tenant, resourceType, resourceID).ToSQL(dialect, 1)
+
+	copySQL := fmt.Sprintf(
+		"INSERT INTO %s (id, tenant, resource, resource_id, name, value, created_at) SELECT id, tenant, resource, resource_id, name, value, created_at FROM %s WHERE %s",
+		dialect.QuoteIdentifier(archiveTableName),
+		dialect.QuoteIdentifier(Metric{}.TableName()),
+		where,
+	)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, copySQL, args...); err != nil {
+		return err
+	}
+	if err := deleteResourceRows(ctx, db, tx, tenant, resourceType, resourceID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CascadeHooks decorates the gorest CRUD hooks of another resource so that
+// deleting one of its rows also cleans up the metrics recorded against it,
+// following the plugin's on_resource_delete setting:
+//
+//	crud.NewWithHooks[Post](db, metrics.NewCascadeHooks[Post](postHooks, metricsPlugin, "post"))
+//
+// Cleanup runs in the delete state processor, before the row itself is
+// removed, so a failing cleanup aborts the delete instead of leaving orphans.
+// Only the metrics of the tenant recorded on the context by WithTenant (see
+// TenantMiddleware) are cleaned up; without one the delete fails rather than
+// cleaning up the default tenant.
+type CascadeHooks[T any] struct {
+	hooks.Hooks[T]
+	plugin       *MetricsPlugin
+	resourceType string
+}
+
+func NewCascadeHooks[T any](inner hooks.Hooks[T], plugin *MetricsPlugin, resourceType string) *CascadeHooks[T] {
+	if inner == nil {
+		inner = hooks.NewNoOpHooks[T]()
+	}
+	return &CascadeHooks[T]{
+		Hooks:        inner,
+		plugin:       plugin,
+		resourceType: resourceType,
+	}
+}
+
+func (h *CascadeHooks[T]) StateProcessor(ctx context.Context, operation hooks.Operation, id any, model *T) error {
+	if err := h.Hooks.StateProcessor(ctx, operation, id, model); err != nil {
+		return err
+	}
+
+	if operation != hooks.OperationDelete || id == nil {
+		return nil
+	}
+
+	tenant, ok := tenantOnContext(ctx)
+	if !ok {
+		return errNoTenant
+	}
+	return h.plugin.CleanupForResource(ctx, tenant, h.resourceType, fmt.Sprint(id))
+}
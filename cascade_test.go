@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cascadePost struct {
+	Id    string `db:"id"`
+	Title string `db:"title"`
+}
+
+func (cascadePost) TableName() string {
+	return "posts"
+}
+
+func newCascadeTestDB(t *testing.T) database.Database {
+	t.Helper()
+
+	db := newTestDB(t)
+	_, err := db.Exec(context.Background(), `CREATE TABLE posts (id VARCHAR(36) PRIMARY KEY, title VARCHAR(255) NOT NULL)`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func insertMetric(t *testing.T, db database.Database, resourceID, key string) {
+	t.Helper()
+	_, err := db.Exec(context.Background(),
+		rebind(db, "INSERT INTO metrics (id, resource, resource_id, name, value) VALUES (?, ?, ?, ?, ?)"),
+		uuid.New().String(), "post", resourceID, key, 1)
+	require.NoError(t, err)
+}
+
+func countRows(t *testing.T, db database.Database, table string) int {
+	t.Helper()
+	var n int
+	require.NoError(t, db.QueryRow(context.Background(), "SELECT COUNT(*) FROM "+table).Scan(&n))
+	return n
+}
+
+func TestMetricsPlugin_DeleteForResource(t *testing.T) {
+	db := newCascadeTestDB(t)
+	p := &MetricsPlugin{db: db, config: DefaultConfig()}
+
+	target := uuid.New().String()
+	other := uuid.New().String()
+	insertMetric(t, db, target, "views")
+	insertMetric(t, db, target, "likes")
+	insertMetric(t, db, other, "views")
+
+	require.NoError(t, p.DeleteForResource(context.Background(), "", "post", target))
+
+	assert.Equal(t, 1, countMetrics(t, db))
+	assert.Equal(t, 0, countRows(t, db, "metrics_archive"))
+}
+
+func TestMetricsPlugin_ArchiveForResource(t *testing.T) {
+	db := newCascadeTestDB(t)
+	p := &MetricsPlugin{db: db, config: DefaultConfig()}
+
+	target := uuid.New().String()
+	insertMetric(t, db, target, "views")
+	insertMetric(t, db, uuid.New().String(), "views")
+
+	require.NoError(t, p.ArchiveForResource(context.Background(), "", "post", target))
+
+	assert.Equal(t, 1, countMetrics(t, db))
+	assert.Equal(t, 1, countRows(t, db, "metrics_archive"))
+}
+
+func TestMetricsPlugin_DeleteForResourceWithoutDatabase(t *testing.T) {
+	p := &MetricsPlugin{config: DefaultConfig()}
+	assert.Error(t, p.DeleteForResource(context.Background(), "", "post", uuid.New().String()))
+}
+
+func TestCascadeHooks_CleansUpOnResourceDelete(t *testing.T) {
+	tests := []struct {
+		name             string
+		onResourceDelete string
+		wantArchived     int
+	}{
+		{name: "delete", onResourceDelete: OnResourceDeleteDelete, wantArchived: 0},
+		{name: "archive", onResourceDelete: OnResourceDeleteArchive, wantArchived: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newCascadeTestDB(t)
+			config := DefaultConfig()
+			config.OnResourceDelete = tt.onResourceDelete
+			p := &MetricsPlugin{db: db, config: config}
+
+			postID := uuid.New().String()
+			_, err := db.Exec(context.Background(), rebind(db, "INSERT INTO posts (id, title) VALUES (?, ?)"), postID, "hello")
+			require.NoError(t, err)
+			insertMetric(t, db, postID, "views")
+
+			posts := crud.NewWithHooks[cascadePost](db, NewCascadeHooks[cascadePost](nil, p, "post"))
+			require.NoError(t, posts.Delete(WithTenant(context.Background(), ""), postID))
+
+			assert.Equal(t, 0, countRows(t, db, "posts"))
+			assert.Equal(t, 0, countMetrics(t, db))
+			assert.Equal(t, tt.wantArchived, countRows(t, db, "metrics_archive"))
+		})
+	}
+}
+
+func TestCascadeHooks_CleansUpOnlyTheContextTenant(t *testing.T) {
+	tests := []struct {
+		name             string
+		onResourceDelete string
+		wantArchived     int
+	}{
+		{name: "delete", onResourceDelete: OnResourceDeleteDelete, wantArchived: 0},
+		{name: "archive", onResourceDelete: OnResourceDeleteArchive, wantArchived: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newCascadeTestDB(t)
+			config := DefaultConfig()
+			config.OnResourceDelete = tt.onResourceDelete
+			p := &MetricsPlugin{db: db, config: config}
+			ctx := context.Background()
+
+			postID := uuid.New().String()
+			_, err := db.Exec(ctx, rebind(db, "INSERT INTO posts (id, title) VALUES (?, ?)"), postID, "hello")
+			require.NoError(t, err)
+			for _, tenant := range []string{"acme", "globex"} {
+				_, err := db.Exec(ctx,
+					rebind(db, "INSERT INTO metrics (id, tenant, resource, resource_id, name, value) VALUES (?, ?, ?, ?, ?, ?)"),
+					uuid.New().String(), tenant, "post", postID, "views", 1)
+				require.NoError(t, err)
+			}
+
+			posts := crud.NewWithHooks[cascadePost](db, NewCascadeHooks[cascadePost](nil, p, "post"))
+			require.NoError(t, posts.Delete(WithTenant(ctx, "acme"), postID))
+
+			globex, err := fetchMetric(ctx, db, Metric{Tenant: "globex", Resource: "post", ResourceId: postID, Key: "views"})
+			require.NoError(t, err)
+			assert.NotNil(t, globex, "other tenants keep their metrics")
+			assert.Equal(t, 1, countMetrics(t, db))
+			assert.Equal(t, tt.wantArchived, countRows(t, db, "metrics_archive"))
+		})
+	}
+}
+
+func TestCascadeHooks_RequiresTenantOnContext(t *testing.T) {
+	db := newCascadeTestDB(t)
+	p := &MetricsPlugin{db: db, config: DefaultConfig()}
+
+	postID := uuid.New().String()
+	_, err := db.Exec(context.Background(), rebind(db, "INSERT INTO posts (id, title) VALUES (?, ?)"), postID, "hello")
+	require.NoError(t, err)
+	insertMetric(t, db, postID, "views")
+
+	// Without TenantMiddleware the tenant is unknown; the delete is refused
+	// rather than cleaning up the default tenant's metrics.
+	posts := crud.NewWithHooks[cascadePost](db, NewCascadeHooks[cascadePost](nil, p, "post"))
+	err = posts.Delete(context.Background(), postID)
+	require.ErrorIs(t, err, errNoTenant)
+
+	assert.Equal(t, 1, countRows(t, db, "posts"))
+	assert.Equal(t, 1, countMetrics(t, db))
+}
+
+func TestMetricsPlugin_CleanupRemovesSketchesAndMilestones(t *testing.T) {
+	tests := []struct {
+		name    string
+		cleanup func(p *MetricsPlugin, ctx context.Context, resourceID string) error
+	}{
+		{name: "delete", cleanup: func(p *MetricsPlugin, ctx context.Context, resourceID string) error {
+			return p.DeleteForResource(ctx, "", "post", resourceID)
+		}},
+		{name: "archive", cleanup: func(p *MetricsPlugin, ctx context.Context, resourceID string) error {
+			return p.ArchiveForResource(ctx, "", "post", resourceID)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			p := &MetricsPlugin{db: db, config: DefaultConfig()}
+			ctx := context.Background()
+
+			target := uuid.New().String()
+			other := uuid.New().String()
+			for _, id := range []string{target, other} {
+				insertMetric(t, db, id, "views")
+				_, err := db.Exec(ctx,
+					rebind(db, "INSERT INTO metric_sketches (tenant, resource, resource_id, name, bucket, sketch) VALUES (?, ?, ?, ?, ?, ?)"),
+					"", "post", id, "viewers", uniqueBucketAll, []byte{0})
+				require.NoError(t, err)
+				_, err = db.Exec(ctx,
+					rebind(db, "INSERT INTO metric_milestones (tenant, resource, resource_id, name, milestone) VALUES (?, ?, ?, ?, ?)"),
+					"", "post", id, "views", 10)
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, tt.cleanup(p, ctx, target))
+
+			assert.Equal(t, 1, countMetrics(t, db))
+			assert.Equal(t, 1, countRows(t, db, sketchesTableName))
+			assert.Equal(t, 1, countRows(t, db, milestonesTableName))
+		})
+	}
+}
@@ -3,26 +3,107 @@ package metrics
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"strings"
 
 	"github.com/nicolasbonnici/gorest/database"
 )
 
+// Resource ID formats accepted in resource_id_formats. A resource type without
+// an explicit entry keeps the historical UUID-only behaviour.
+const (
+	IDFormatUUID   = "uuid"
+	IDFormatInt    = "int"
+	IDFormatString = "string"
+)
+
+// MaxResourceIDLength matches the width of the resource_id column.
+const MaxResourceIDLength = 255
+
+// MaxVisitorLength bounds the visitor identifier of a unique metric. Only its
+// hash is stored.
+const MaxVisitorLength = 255
+
+// MaxActorLength bounds the actor used for deduplication. Only its hash is
+// stored.
+const MaxActorLength = 255
+
+const defaultMaxBatchResourceIDs = 100
+
+// IDFormat describes how resource IDs of one resource type are validated.
+// Pattern is only used by the string format and must match the whole ID; when
+// empty any non-empty ID up to MaxResourceIDLength is accepted.
+type IDFormat struct {
+	Type    string `json:"type" yaml:"type"`
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+}
+
+// RouteMetric maps a route to the metric Handler increments when the route
+// answers with a 2xx status. Metric is "<resource>/:<param>/<key>", the
+// resource ID being read from the named route parameter. Statuses narrows the
+// 2xx statuses that count; SampleRate (0 < rate <= 1, 0 meaning 1) records
+// that fraction of requests, each weighted by 1/rate so totals stay unbiased.
+type RouteMetric struct {
+	Metric     string  `json:"metric" yaml:"metric"`
+	Statuses   []int   `json:"statuses,omitempty" yaml:"statuses,omitempty"`
+	SampleRate float64 `json:"sample_rate,omitempty" yaml:"sample_rate,omitempty"`
+}
+
+// routeTarget is a validated RouteMetric.
+type routeTarget struct {
+	resource string
+	param    string
+	key      string
+	statuses map[int]bool
+	rate     float64
+	weight   int
+}
+
 type Config struct {
-	Database           database.Database
-	AllowedTypes       []string `json:"allowed_types" yaml:"allowed_types"`
-	MaxKeyLength       int      `json:"max_key_length" yaml:"max_key_length"`
-	OnlyPositiveValues bool     `json:"only_positive_values" yaml:"only_positive_values"`
-	PaginationLimit    int      `json:"pagination_limit" yaml:"pagination_limit"`
-	MaxPaginationLimit int      `json:"max_pagination_limit" yaml:"max_pagination_limit"`
+	Database             database.Database
+	Authorizer           Authorizer
+	TenantExtractor      TenantExtractor
+	AllowedTypes         []string               `json:"allowed_types" yaml:"allowed_types"`
+	ResourceIDFormats    map[string]IDFormat    `json:"resource_id_formats" yaml:"resource_id_formats"`
+	MaxKeyLength         int                    `json:"max_key_length" yaml:"max_key_length"`
+	OnlyPositiveValues   bool                   `json:"only_positive_values" yaml:"only_positive_values"`
+	PaginationLimit      int                    `json:"pagination_limit" yaml:"pagination_limit"`
+	MaxPaginationLimit   int                    `json:"max_pagination_limit" yaml:"max_pagination_limit"`
+	OnResourceDelete     string                 `json:"on_resource_delete" yaml:"on_resource_delete"`
+	MaxBatchResourceIDs  int                    `json:"max_batch_resource_ids" yaml:"max_batch_resource_ids"`
+	StreamBufferSize     int                    `json:"stream_buffer_size" yaml:"stream_buffer_size"`
+	SubscriberBufferSize int                    `json:"subscriber_buffer_size" yaml:"subscriber_buffer_size"`
+	Milestones           map[string][]int       `json:"milestones" yaml:"milestones"`
+	UniqueKeys           []string               `json:"unique_keys" yaml:"unique_keys"`
+	DedupWindowSeconds   int                    `json:"dedup_window_seconds" yaml:"dedup_window_seconds"`
+	DedupCacheSize       int                    `json:"dedup_cache_size" yaml:"dedup_cache_size"`
+	DedupStore           string                 `json:"dedup_store" yaml:"dedup_store"`
+	RateLimits           map[string]RateLimit   `json:"rate_limits" yaml:"rate_limits"`
+	RouteMetrics         map[string]RouteMetric `json:"route_metrics" yaml:"route_metrics"`
+	AdminRole            string                 `json:"admin_role" yaml:"admin_role"`
+	KeyVisibility        map[string]string      `json:"key_visibility" yaml:"key_visibility"`
+	TenantHeader         string                 `json:"tenant_header" yaml:"tenant_header"`
+	TenantQuota          TenantQuota            `json:"tenant_quota" yaml:"tenant_quota"`
+	TenantQuotas         map[string]TenantQuota `json:"tenant_quotas" yaml:"tenant_quotas"`
+
+	// idPatterns caches the compiled string-format patterns built by Validate.
+	idPatterns map[string]*regexp.Regexp
+	// routeTargets holds the parsed RouteMetrics keyed by "METHOD /path".
+	routeTargets map[string]routeTarget
 }
 
 func DefaultConfig() Config {
 	return Config{
-		AllowedTypes:       []string{"post"},
-		MaxKeyLength:       255,
-		OnlyPositiveValues: false,
-		PaginationLimit:    50,
-		MaxPaginationLimit: 200,
+		AllowedTypes:         []string{"post"},
+		MaxKeyLength:         255,
+		OnlyPositiveValues:   false,
+		PaginationLimit:      50,
+		MaxPaginationLimit:   200,
+		OnResourceDelete:     OnResourceDeleteDelete,
+		MaxBatchResourceIDs:  defaultMaxBatchResourceIDs,
+		StreamBufferSize:     defaultStreamBufferSize,
+		SubscriberBufferSize: defaultSubscriberBufferSize,
 	}
 }
 
@@ -42,6 +123,10 @@ func (c *Config) Validate() error {
 		seen[resourceType] = true
 	}
 
+	if err := c.validateResourceIDFormats(); err != nil {
+		return err
+	}
+
 	if c.MaxKeyLength < 1 || c.MaxKeyLength > 255 {
 		return errors.New("max_key_length must be between 1 and 255")
 	}
@@ -54,6 +139,191 @@ func (c *Config) Validate() error {
 		return errors.New("max_pagination_limit must be between 1 and 1000")
 	}
 
+	if c.MaxBatchResourceIDs < 0 || c.MaxBatchResourceIDs > 1000 {
+		return errors.New("max_batch_resource_ids must be between 0 (default) and 1000")
+	}
+
+	if c.StreamBufferSize < 0 || c.StreamBufferSize > 10000 {
+		return errors.New("stream_buffer_size must be between 0 (default) and 10000")
+	}
+
+	if c.SubscriberBufferSize < 0 || c.SubscriberBufferSize > 100000 {
+		return errors.New("subscriber_buffer_size must be between 0 (default) and 100000")
+	}
+
+	if c.DedupWindowSeconds < 0 || c.DedupWindowSeconds > 604800 {
+		return errors.New("dedup_window_seconds must be between 0 (disabled) and 604800")
+	}
+
+	if c.DedupCacheSize < 0 || c.DedupCacheSize > 10000000 {
+		return errors.New("dedup_cache_size must be between 0 (default) and 10000000")
+	}
+
+	switch c.DedupStore {
+	case "", DedupStoreMemory, DedupStoreDatabase:
+	default:
+		return errors.New("dedup_store must be either memory or database")
+	}
+
+	for scope, limit := range c.RateLimits {
+		switch scope {
+		case RateLimitByIP, RateLimitByUser, RateLimitByMetric:
+		default:
+			return fmt.Errorf("rate_limits: unknown scope %s, expected ip, user or metric", scope)
+		}
+		if limit.RequestsPerSecond <= 0 {
+			return fmt.Errorf("rate_limits.%s: requests_per_second must be positive", scope)
+		}
+		if limit.Burst < 0 {
+			return fmt.Errorf("rate_limits.%s: burst must be 0 (default) or positive", scope)
+		}
+	}
+
+	if err := c.TenantQuota.validate("tenant_quota"); err != nil {
+		return err
+	}
+	for tenant, quota := range c.TenantQuotas {
+		if err := quota.validate("tenant_quotas." + tenant); err != nil {
+			return err
+		}
+	}
+
+	switch c.OnResourceDelete {
+	case "", OnResourceDeleteDelete, OnResourceDeleteArchive:
+	default:
+		return errors.New("on_resource_delete must be either delete or archive")
+	}
+
+	seenKeys := make(map[string]bool, len(c.UniqueKeys))
+	for _, key := range c.UniqueKeys {
+		if key == "" {
+			return errors.New("unique_keys cannot contain empty strings")
+		}
+		if len(key) > c.MaxKeyLength {
+			return fmt.Errorf("unique_keys: %s exceeds max_key_length", key)
+		}
+		if seenKeys[key] {
+			return fmt.Errorf("duplicate key in unique_keys: %s", key)
+		}
+		seenKeys[key] = true
+	}
+
+	for key, visibility := range c.KeyVisibility {
+		if key == "" {
+			return errors.New("key_visibility cannot contain empty keys")
+		}
+		switch visibility {
+		case VisibilityPublic, VisibilityPrivate:
+		default:
+			return fmt.Errorf("key_visibility.%s: must be either public or private", key)
+		}
+	}
+
+	if err := c.validateRouteMetrics(); err != nil {
+		return err
+	}
+
+	for key, thresholds := range c.Milestones {
+		if key == "" {
+			return errors.New("milestones cannot contain empty keys")
+		}
+		seen := make(map[int]bool, len(thresholds))
+		for _, threshold := range thresholds {
+			if threshold < 1 {
+				return fmt.Errorf("milestones.%s: thresholds must be positive", key)
+			}
+			if seen[threshold] {
+				return fmt.Errorf("milestones.%s: duplicate threshold %d", key, threshold)
+			}
+			seen[threshold] = true
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateResourceIDFormats() error {
+	c.idPatterns = make(map[string]*regexp.Regexp)
+
+	for resourceType, format := range c.ResourceIDFormats {
+		if !c.IsAllowedType(resourceType) {
+			return fmt.Errorf("resource_id_formats references unknown type: %s", resourceType)
+		}
+
+		switch format.Type {
+		case IDFormatUUID, IDFormatInt:
+			if format.Pattern != "" {
+				return fmt.Errorf("resource_id_formats.%s: pattern is only supported by the string format", resourceType)
+			}
+		case IDFormatString:
+			if format.Pattern == "" {
+				continue
+			}
+			re, err := compileIDPattern(format.Pattern)
+			if err != nil {
+				return fmt.Errorf("resource_id_formats.%s: invalid pattern: %w", resourceType, err)
+			}
+			c.idPatterns[resourceType] = re
+		default:
+			return fmt.Errorf("resource_id_formats.%s: unsupported type %q", resourceType, format.Type)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateRouteMetrics() error {
+	c.routeTargets = make(map[string]routeTarget, len(c.RouteMetrics))
+
+	for route, rm := range c.RouteMetrics {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("route_metrics: route %q must be \"METHOD /path\"", route)
+		}
+
+		parts := strings.SplitN(rm.Metric, "/", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[1], ":") || len(parts[1]) < 2 || parts[2] == "" {
+			return fmt.Errorf("route_metrics.%s: metric %q must be \"resource/:param/key\"", route, rm.Metric)
+		}
+		if !c.IsAllowedType(parts[0]) {
+			return fmt.Errorf("route_metrics.%s: unknown resource type %s", route, parts[0])
+		}
+		if len(parts[2]) > c.MaxKeyLength {
+			return fmt.Errorf("route_metrics.%s: key exceeds max_key_length", route)
+		}
+		if c.IsUniqueKey(parts[2]) {
+			return fmt.Errorf("route_metrics.%s: %s is a unique key and cannot be incremented", route, parts[2])
+		}
+
+		target := routeTarget{
+			resource: parts[0],
+			param:    parts[1][1:],
+			key:      parts[2],
+			rate:     rm.SampleRate,
+			weight:   1,
+		}
+
+		for _, status := range rm.Statuses {
+			if status < 200 || status > 299 {
+				return fmt.Errorf("route_metrics.%s: statuses must be 2xx", route)
+			}
+			if target.statuses == nil {
+				target.statuses = make(map[int]bool, len(rm.Statuses))
+			}
+			target.statuses[status] = true
+		}
+
+		if rm.SampleRate < 0 || rm.SampleRate > 1 {
+			return fmt.Errorf("route_metrics.%s: sample_rate must be between 0 (default) and 1", route)
+		}
+		if rm.SampleRate == 0 {
+			target.rate = 1
+		}
+		target.weight = int(math.Round(1 / target.rate))
+
+		c.routeTargets[strings.ToUpper(method)+" "+path] = target
+	}
+
 	return nil
 }
 
@@ -65,3 +335,45 @@ func (c *Config) IsAllowedType(resourceType string) bool {
 	}
 	return false
 }
+
+// IsUniqueKey reports whether key counts distinct visitors rather than
+// holding a plain value.
+func (c *Config) IsUniqueKey(key string) bool {
+	for _, unique := range c.UniqueKeys {
+		if unique == key {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchResourceIDsLimit returns how many resource IDs a batched aggregate
+// request may ask for; zero means the default.
+func (c *Config) BatchResourceIDsLimit() int {
+	if c.MaxBatchResourceIDs == 0 {
+		return defaultMaxBatchResourceIDs
+	}
+	return c.MaxBatchResourceIDs
+}
+
+// ResourceIDFormat returns the ID format configured for resourceType, falling
+// back to UUID.
+func (c *Config) ResourceIDFormat(resourceType string) IDFormat {
+	if format, ok := c.ResourceIDFormats[resourceType]; ok && format.Type != "" {
+		return format
+	}
+	return IDFormat{Type: IDFormatUUID}
+}
+
+// resourceIDPattern returns the compiled pattern for a string-format resource
+// type, compiling it on the fly when Validate has not been called.
+func (c *Config) resourceIDPattern(resourceType string, format IDFormat) (*regexp.Regexp, error) {
+	if re, ok := c.idPatterns[resourceType]; ok {
+		return re, nil
+	}
+	return compileIDPattern(format.Pattern)
+}
+
+func compileIDPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
@@ -90,6 +90,395 @@ func TestConfig_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "pagination_limit must be between 1 and max_pagination_limit",
 		},
+		{
+			name: "valid resource id formats",
+			config: Config{
+				AllowedTypes: []string{"post", "user", "tag"},
+				ResourceIDFormats: map[string]IDFormat{
+					"user": {Type: IDFormatInt},
+					"tag":  {Type: IDFormatString, Pattern: "[a-z0-9-]+"},
+				},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+			},
+			wantErr: false,
+		},
+		{
+			name: "resource id format for unknown type",
+			config: Config{
+				AllowedTypes: []string{"post"},
+				ResourceIDFormats: map[string]IDFormat{
+					"user": {Type: IDFormatInt},
+				},
+			},
+			wantErr: true,
+			errMsg:  "resource_id_formats references unknown type: user",
+		},
+		{
+			name: "unsupported resource id format",
+			config: Config{
+				AllowedTypes: []string{"post"},
+				ResourceIDFormats: map[string]IDFormat{
+					"post": {Type: "ulid"},
+				},
+			},
+			wantErr: true,
+			errMsg:  `resource_id_formats.post: unsupported type "ulid"`,
+		},
+		{
+			name: "pattern on non-string resource id format",
+			config: Config{
+				AllowedTypes: []string{"post"},
+				ResourceIDFormats: map[string]IDFormat{
+					"post": {Type: IDFormatInt, Pattern: "[0-9]+"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "resource_id_formats.post: pattern is only supported by the string format",
+		},
+		{
+			name: "invalid resource id pattern",
+			config: Config{
+				AllowedTypes: []string{"post"},
+				ResourceIDFormats: map[string]IDFormat{
+					"post": {Type: IDFormatString, Pattern: "[a-z"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid on resource delete action",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				OnResourceDelete:   "ignore",
+			},
+			wantErr: true,
+			errMsg:  "on_resource_delete must be either delete or archive",
+		},
+		{
+			name: "valid milestones",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				Milestones:         map[string][]int{"views": {1000, 100}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "non-positive milestone",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				Milestones:         map[string][]int{"views": {0}},
+			},
+			wantErr: true,
+			errMsg:  "milestones.views: thresholds must be positive",
+		},
+		{
+			name: "duplicate milestone",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				Milestones:         map[string][]int{"views": {100, 100}},
+			},
+			wantErr: true,
+			errMsg:  "milestones.views: duplicate threshold 100",
+		},
+		{
+			name: "valid route metrics",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RouteMetrics:       map[string]RouteMetric{"GET /posts/:id": {Metric: "post/:id/views", Statuses: []int{200}, SampleRate: 0.5}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route metric without method",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RouteMetrics:       map[string]RouteMetric{"/posts/:id": {Metric: "post/:id/views"}},
+			},
+			wantErr: true,
+			errMsg:  "route_metrics: route \"/posts/:id\" must be \"METHOD /path\"",
+		},
+		{
+			name: "route metric without param",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RouteMetrics:       map[string]RouteMetric{"GET /posts/:id": {Metric: "post/views"}},
+			},
+			wantErr: true,
+			errMsg:  "route_metrics.GET /posts/:id: metric \"post/views\" must be \"resource/:param/key\"",
+		},
+		{
+			name: "route metric with unknown resource",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RouteMetrics:       map[string]RouteMetric{"GET /users/:id": {Metric: "user/:id/views"}},
+			},
+			wantErr: true,
+			errMsg:  "route_metrics.GET /users/:id: unknown resource type user",
+		},
+		{
+			name: "route metric with non-2xx status",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RouteMetrics:       map[string]RouteMetric{"GET /posts/:id": {Metric: "post/:id/views", Statuses: []int{404}}},
+			},
+			wantErr: true,
+			errMsg:  "route_metrics.GET /posts/:id: statuses must be 2xx",
+		},
+		{
+			name: "route metric sample rate too large",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RouteMetrics:       map[string]RouteMetric{"GET /posts/:id": {Metric: "post/:id/views", SampleRate: 1.5}},
+			},
+			wantErr: true,
+			errMsg:  "route_metrics.GET /posts/:id: sample_rate must be between 0 (default) and 1",
+		},
+		{
+			name: "valid unique keys",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				UniqueKeys:         []string{"viewers"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "empty unique key",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				UniqueKeys:         []string{""},
+			},
+			wantErr: true,
+			errMsg:  "unique_keys cannot contain empty strings",
+		},
+		{
+			name: "duplicate unique key",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				UniqueKeys:         []string{"viewers", "viewers"},
+			},
+			wantErr: true,
+			errMsg:  "duplicate key in unique_keys: viewers",
+		},
+		{
+			name: "route metric on unique key",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				UniqueKeys:         []string{"viewers"},
+				RouteMetrics:       map[string]RouteMetric{"GET /posts/:id": {Metric: "post/:id/viewers"}},
+			},
+			wantErr: true,
+			errMsg:  "route_metrics.GET /posts/:id: viewers is a unique key and cannot be incremented",
+		},
+		{
+			name: "valid dedup window",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				DedupWindowSeconds: 600,
+				DedupStore:         DedupStoreDatabase,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative dedup window",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				DedupWindowSeconds: -1,
+			},
+			wantErr: true,
+			errMsg:  "dedup_window_seconds must be between 0 (disabled) and 604800",
+		},
+		{
+			name: "dedup cache too large",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				DedupCacheSize:     10000001,
+			},
+			wantErr: true,
+			errMsg:  "dedup_cache_size must be between 0 (default) and 10000000",
+		},
+		{
+			name: "invalid dedup store",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				DedupStore:         "redis",
+			},
+			wantErr: true,
+			errMsg:  "dedup_store must be either memory or database",
+		},
+		{
+			name: "valid rate limits",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RateLimits:         map[string]RateLimit{"ip": {RequestsPerSecond: 5, Burst: 20}, "metric": {RequestsPerSecond: 0.5}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown rate limit scope",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RateLimits:         map[string]RateLimit{"tenant": {RequestsPerSecond: 5}},
+			},
+			wantErr: true,
+			errMsg:  "rate_limits: unknown scope tenant, expected ip, user or metric",
+		},
+		{
+			name: "rate limit without rate",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RateLimits:         map[string]RateLimit{"user": {Burst: 5}},
+			},
+			wantErr: true,
+			errMsg:  "rate_limits.user: requests_per_second must be positive",
+		},
+		{
+			name: "negative rate limit burst",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				RateLimits:         map[string]RateLimit{"ip": {RequestsPerSecond: 1, Burst: -1}},
+			},
+			wantErr: true,
+			errMsg:  "rate_limits.ip: burst must be 0 (default) or positive",
+		},
+		{
+			name: "valid tenant quotas",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				TenantQuota:        TenantQuota{MaxMetrics: 1000, WritesPerSecond: 10},
+				TenantQuotas:       map[string]TenantQuota{"acme": {MaxMetrics: 100000, WritesPerSecond: 100, WriteBurst: 500}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative tenant quota",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				TenantQuota:        TenantQuota{MaxMetrics: -1},
+			},
+			wantErr: true,
+			errMsg:  "tenant_quota: max_metrics must be 0 (unlimited) or positive",
+		},
+		{
+			name: "negative tenant write burst",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				TenantQuotas:       map[string]TenantQuota{"acme": {WritesPerSecond: 1, WriteBurst: -1}},
+			},
+			wantErr: true,
+			errMsg:  "tenant_quotas.acme: write_burst must be 0 (default) or positive",
+		},
+		{
+			name: "valid key visibility",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				KeyVisibility:      map[string]string{"revenue": VisibilityPrivate, "views": VisibilityPublic},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown key visibility",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				KeyVisibility:      map[string]string{"revenue": "hidden"},
+			},
+			wantErr: true,
+			errMsg:  "key_visibility.revenue: must be either public or private",
+		},
+		{
+			name: "empty key visibility key",
+			config: Config{
+				AllowedTypes:       []string{"post"},
+				MaxKeyLength:       255,
+				PaginationLimit:    50,
+				MaxPaginationLimit: 200,
+				KeyVisibility:      map[string]string{"": VisibilityPrivate},
+			},
+			wantErr: true,
+			errMsg:  "key_visibility cannot contain empty keys",
+		},
 		{
 			name: "max pagination limit too large",
 			config: Config{
@@ -169,6 +558,23 @@ func TestConfig_IsAllowedType(t *testing.T) {
 	}
 }
 
+func TestConfig_ResourceIDFormat(t *testing.T) {
+	config := Config{
+		AllowedTypes: []string{"post", "user"},
+		ResourceIDFormats: map[string]IDFormat{
+			"user": {Type: IDFormatInt},
+		},
+	}
+
+	if got := config.ResourceIDFormat("user").Type; got != IDFormatInt {
+		t.Errorf("ResourceIDFormat(user) = %v, want %v", got, IDFormatInt)
+	}
+
+	if got := config.ResourceIDFormat("post").Type; got != IDFormatUUID {
+		t.Errorf("ResourceIDFormat(post) = %v, want %v", got, IDFormatUUID)
+	}
+}
+
 func TestDefaultConfig(t *testing.T) {
 	config := DefaultConfig()
 
@@ -25,11 +25,15 @@ func (c *MetricConverter) UpdateDTOToModel(dto MetricUpdateDTO) Metric {
 func (c *MetricConverter) ModelToResponseDTO(model Metric) MetricResponseDTO {
 	return MetricResponseDTO{
 		ID:         model.Id,
+		Tenant:     model.Tenant,
 		Resource:   model.Resource,
 		ResourceID: model.ResourceId,
 		Key:        model.Key,
 		Value:      model.Value,
+		Version:    model.Version,
 		CreatedAt:  model.CreatedAt,
+		UpdatedAt:  model.UpdatedAt,
+		DeletedAt:  model.DeletedAt,
 	}
 }
 
@@ -40,3 +44,82 @@ func (c *MetricConverter) ModelsToResponseDTOs(models []Metric) []MetricResponse
 	}
 	return dtos
 }
+
+type AlertRuleConverter struct{}
+
+func (c *AlertRuleConverter) CreateDTOToModel(dto AlertRuleCreateDTO) AlertRule {
+	return AlertRule{
+		Id:              uuid.New().String(),
+		Resource:        dto.Resource,
+		ResourceId:      dto.ResourceId,
+		Key:             dto.Key,
+		Operator:        dto.Operator,
+		Threshold:       dto.Threshold,
+		WebhookURL:      dto.WebhookURL,
+		Secret:          dto.Secret,
+		CooldownSeconds: dto.CooldownSeconds,
+		Enabled:         dto.Enabled == nil || *dto.Enabled,
+	}
+}
+
+func (c *AlertRuleConverter) UpdateDTOToModel(dto AlertRuleUpdateDTO) AlertRule {
+	return AlertRule{
+		Resource:        dto.Resource,
+		ResourceId:      dto.ResourceId,
+		Key:             dto.Key,
+		Operator:        dto.Operator,
+		Threshold:       dto.Threshold,
+		WebhookURL:      dto.WebhookURL,
+		Secret:          dto.Secret,
+		CooldownSeconds: dto.CooldownSeconds,
+		Enabled:         dto.Enabled == nil || *dto.Enabled,
+	}
+}
+
+func (c *AlertRuleConverter) ModelToResponseDTO(model AlertRule) AlertRuleResponseDTO {
+	return AlertRuleResponseDTO{
+		ID:              model.Id,
+		Resource:        model.Resource,
+		ResourceID:      model.ResourceId,
+		Key:             model.Key,
+		Operator:        model.Operator,
+		Threshold:       model.Threshold,
+		WebhookURL:      model.WebhookURL,
+		CooldownSeconds: model.CooldownSeconds,
+		Enabled:         model.Enabled,
+		CreatedAt:       model.CreatedAt,
+	}
+}
+
+func (c *AlertRuleConverter) ModelsToResponseDTOs(models []AlertRule) []AlertRuleResponseDTO {
+	dtos := make([]AlertRuleResponseDTO, len(models))
+	for i, model := range models {
+		dtos[i] = c.ModelToResponseDTO(model)
+	}
+	return dtos
+}
+
+type AuditEntryConverter struct{}
+
+func (c *AuditEntryConverter) ModelToResponseDTO(model AuditEntry) AuditEntryResponseDTO {
+	return AuditEntryResponseDTO{
+		ID:         model.Id,
+		MetricID:   model.MetricId,
+		Resource:   model.Resource,
+		ResourceID: model.ResourceId,
+		Key:        model.Key,
+		Operation:  model.Operation,
+		Actor:      model.Actor,
+		OldValue:   model.OldValue,
+		NewValue:   model.NewValue,
+		CreatedAt:  model.CreatedAt,
+	}
+}
+
+func (c *AuditEntryConverter) ModelsToResponseDTOs(models []AuditEntry) []AuditEntryResponseDTO {
+	dtos := make([]AuditEntryResponseDTO, len(models))
+	for i, model := range models {
+		dtos[i] = c.ModelToResponseDTO(model)
+	}
+	return dtos
+}
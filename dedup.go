@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/logger"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// Stores backing the dedup window.
+const (
+	DedupStoreMemory   = "memory"
+	DedupStoreDatabase = "database"
+)
+
+const (
+	dedupTableName         = "metric_dedup"
+	defaultDedupCacheSize  = 100000
+	defaultDedupPruneEvery = time.Minute
+)
+
+// dedupFilter drops the events an actor repeats on the same metric within the
+// configured window. The in-memory LRU answers for this instance; with the
+// database store, metric_dedup makes the window hold across instances. A nil
+// filter lets every event through.
+type dedupFilter struct {
+	window time.Duration
+	cache  *dedupCache
+	db     database.Database
+	now    func() time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newDedupFilter returns nil when config disables deduplication.
+func newDedupFilter(db database.Database, config *Config) *dedupFilter {
+	if config.DedupWindowSeconds <= 0 {
+		return nil
+	}
+
+	size := config.DedupCacheSize
+	if size <= 0 {
+		size = defaultDedupCacheSize
+	}
+
+	f := &dedupFilter{
+		window: time.Duration(config.DedupWindowSeconds) * time.Second,
+		cache:  newDedupCache(size),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+
+	if config.DedupStore == DedupStoreDatabase {
+		f.db = db
+		f.wg.Add(1)
+		go f.prune()
+	}
+
+	return f
+}
+
+// duplicate reports whether actor already counted for m within the window,
+// and otherwise opens a new window for it. Events without an actor are never
+// duplicates. Database errors let the event through: counting an event twice
+// beats losing it.
+func (f *dedupFilter) duplicate(ctx context.Context, m Metric, actor string) bool {
+	if f == nil || actor == "" {
+		return false
+	}
+
+	key := dedupKey(m, actor)
+	now := f.now()
+	if f.cache.seen(key, now) {
+		return true
+	}
+
+	if f.db != nil {
+		claimed, err := f.claim(ctx, key, now)
+		if err != nil {
+			logger.Log.Warn("metrics: dedup store unavailable, counting event",
+				"error", err,
+				"resource", m.Resource,
+				"key", m.Key,
+			)
+		} else if !claimed {
+			return true
+		}
+	}
+
+	f.cache.add(key, now.Add(f.window))
+	return false
+}
+
+// claim records key in metric_dedup unless another instance holds an
+// unexpired window for it. The upsert only overwrites expired rows, so the
+// number of affected rows tells whether this call opened the window.
+func (f *dedupFilter) claim(ctx context.Context, key string, now time.Time) (bool, error) {
+	dialect := f.db.Dialect()
+	expiresAt := now.Add(f.window).UnixMilli()
+
+	insertSQL, args, err := query.New(dialect).
+		Insert(dedupTableName).
+		Columns("dedup_key", "expires_at").
+		Values(key, expiresAt).
+		Build()
+	if err != nil {
+		return false, err
+	}
+
+	col := dialect.QuoteIdentifier("expires_at")
+	var update string
+	if f.db.DriverName() == "mysql" {
+		update = fmt.Sprintf("ON DUPLICATE KEY UPDATE %s = IF(%s <= %d, VALUES(%s), %s)",
+			col, col, now.UnixMilli(), col, col)
+	} else {
+		update = dialect.OnConflictClause([]string{"dedup_key"}, fmt.Sprintf(
+			"DO UPDATE SET %s = excluded.%s WHERE %s.%s <= %d",
+			col, col, dialect.QuoteIdentifier(dedupTableName), col, now.UnixMilli()))
+	}
+
+	res, err := f.db.Exec(ctx, insertSQL+" "+update, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// prune deletes expired windows from metric_dedup so actors that never come
+// back do not accumulate.
+func (f *dedupFilter) prune() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(defaultDedupPruneEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.deleteExpired(context.Background()); err != nil {
+				logger.Log.Warn("metrics: failed to prune dedup windows", "error", err)
+			}
+		}
+	}
+}
+
+func (f *dedupFilter) deleteExpired(ctx context.Context) error {
+	sqlStr, args, err := query.New(f.db.Dialect()).
+		Delete(dedupTableName).
+		Where(query.Lte("expires_at", f.now().UnixMilli())).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultWriteTimeout)
+	defer cancel()
+	_, err = f.db.Exec(ctx, sqlStr, args...)
+	return err
+}
+
+func (f *dedupFilter) shutdown(ctx context.Context) error {
+	if f == nil || f.db == nil {
+		return nil
+	}
+
+	close(f.stop)
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dedupKey hashes the tuple and actor to a fixed-width key, so long IDs and
+// actors fit the metric_dedup primary key.
+func dedupKey(m Metric, actor string) string {
+	sum := sha256.Sum256([]byte(m.Tenant + "\x00" + m.Resource + "\x00" + m.ResourceId + "\x00" + m.Key + "\x00" + actor))
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupCache is a fixed-size LRU of open dedup windows. Once full, the least
+// recently counted window is forgotten first.
+type dedupCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newDedupCache(size int) *dedupCache {
+	return &dedupCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// seen reports whether key has a window open at now.
+func (c *dedupCache) seen(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(el.Value.(*dedupEntry).expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+// add opens a window for key until expiresAt.
+func (c *dedupCache) add(key string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*dedupEntry).expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&dedupEntry{key: key, expiresAt: expiresAt})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dedupEntry).key)
+	}
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestDedupFilter(t *testing.T, db database.Database, config Config, clock *fakeClock) *dedupFilter {
+	t.Helper()
+
+	f := newDedupFilter(db, &config)
+	require.NotNil(t, f)
+	f.now = clock.Now
+	t.Cleanup(func() { _ = f.shutdown(context.Background()) })
+	return f
+}
+
+func TestNewDedupFilter_DisabledByDefault(t *testing.T) {
+	config := DefaultConfig()
+	f := newDedupFilter(nil, &config)
+	assert.Nil(t, f)
+	assert.False(t, f.duplicate(context.Background(), sampleMetric(), "alice"))
+	assert.NoError(t, f.shutdown(context.Background()))
+}
+
+func TestDedupFilter_Memory(t *testing.T) {
+	config := DefaultConfig()
+	config.DedupWindowSeconds = 600
+	clock := &fakeClock{now: time.Now()}
+	f := newTestDedupFilter(t, nil, config, clock)
+	ctx := context.Background()
+
+	views := sampleMetric()
+	other := sampleMetric()
+
+	assert.False(t, f.duplicate(ctx, views, "alice"))
+	assert.True(t, f.duplicate(ctx, views, "alice"))
+	assert.False(t, f.duplicate(ctx, views, "bob"), "windows are per actor")
+	assert.False(t, f.duplicate(ctx, other, "alice"), "windows are per metric")
+	assert.False(t, f.duplicate(ctx, views, ""), "events without an actor always count")
+	assert.False(t, f.duplicate(ctx, views, ""))
+
+	clock.Advance(9 * time.Minute)
+	assert.True(t, f.duplicate(ctx, views, "alice"), "a repeat does not extend the window")
+	clock.Advance(time.Minute)
+	assert.False(t, f.duplicate(ctx, views, "alice"))
+	assert.True(t, f.duplicate(ctx, views, "alice"))
+}
+
+func TestDedupFilter_DatabaseSharedAcrossInstances(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	config.DedupWindowSeconds = 60
+	config.DedupStore = DedupStoreDatabase
+	clock := &fakeClock{now: time.Now()}
+	first := newTestDedupFilter(t, db, config, clock)
+	second := newTestDedupFilter(t, db, config, clock)
+	ctx := context.Background()
+	views := sampleMetric()
+
+	assert.False(t, first.duplicate(ctx, views, "alice"))
+	assert.True(t, second.duplicate(ctx, views, "alice"), "the window holds on another instance")
+	assert.False(t, second.duplicate(ctx, views, "bob"))
+
+	clock.Advance(time.Minute)
+	assert.False(t, second.duplicate(ctx, views, "alice"), "an expired window is claimed again")
+	assert.True(t, first.duplicate(ctx, views, "alice"))
+
+	clock.Advance(time.Minute)
+	require.NoError(t, first.deleteExpired(ctx))
+	assert.Equal(t, 0, countRows(t, db, dedupTableName))
+}
+
+func TestDedupFilter_DatabaseErrorsCountTheEvent(t *testing.T) {
+	// No metric_dedup table: the store fails and the event is counted.
+	config := DefaultConfig()
+	config.DedupWindowSeconds = 60
+	config.DedupStore = DedupStoreDatabase
+	f := newTestDedupFilter(t, newTestDB(t), config, &fakeClock{now: time.Now()})
+	ctx := context.Background()
+	views := sampleMetric()
+
+	assert.False(t, f.duplicate(ctx, views, "alice"))
+	assert.True(t, f.duplicate(ctx, views, "alice"), "the local cache still applies")
+}
+
+func TestDedupCache_EvictsLeastRecentlyCounted(t *testing.T) {
+	c := newDedupCache(2)
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	c.add("a", later)
+	c.add("b", later)
+	c.add("a", later)
+	c.add("c", later)
+
+	assert.True(t, c.seen("a", now))
+	assert.False(t, c.seen("b", now), "b was the least recently counted")
+	assert.True(t, c.seen("c", now))
+	assert.False(t, c.seen("c", later), "windows end at expiresAt")
+	assert.Equal(t, 1, c.order.Len())
+}
+
+func TestMetricResource_CreateDeduplicatesActor(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	config.DedupWindowSeconds = 600
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	defer func() { _ = writer.shutdown(context.Background()) }()
+	app := fiber.New()
+	RegisterMetricRoutes(app, db, &config, writer, nil, newDedupFilter(db, &config), nil, nil)
+
+	resourceID := uuid.New().String()
+	create := func(actor string) int {
+		status, body := doJSON(t, app, http.MethodPost, "/metrics", MetricCreateDTO{
+			Resource: "post", ResourceId: resourceID, Key: "views", Value: 1, Actor: actor,
+		})
+		require.Less(t, status, 300, string(body))
+		return status
+	}
+
+	assert.Equal(t, http.StatusCreated, create("alice"))
+	assert.Equal(t, http.StatusOK, create("alice"))
+	assert.Equal(t, http.StatusCreated, create("bob"))
+	assert.Equal(t, http.StatusCreated, create(""))
+	assert.Equal(t, http.StatusCreated, create(""))
+
+	status, body := doJSON(t, app, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "views", Actor: string(make([]byte, MaxActorLength+1)),
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, string(body), "actor exceeds maximum length")
+}
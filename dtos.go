@@ -4,11 +4,16 @@ import (
 	"time"
 )
 
+// MetricCreateDTO creates a metric. For keys listed in unique_keys, Visitor
+// identifies who is counted and Value must be left out. Actor, when set,
+// drops repeats of the same event within dedup_window_seconds.
 type MetricCreateDTO struct {
 	Resource   string `json:"resource"`
 	ResourceId string `json:"resourceId"`
 	Key        string `json:"key"`
 	Value      int    `json:"value"`
+	Visitor    string `json:"visitor,omitempty"`
+	Actor      string `json:"actor,omitempty"`
 }
 
 type MetricUpdateDTO struct {
@@ -17,9 +22,67 @@ type MetricUpdateDTO struct {
 
 type MetricResponseDTO struct {
 	ID         string     `json:"id"`
+	Tenant     string     `json:"tenant,omitempty"`
 	Resource   string     `json:"resource"`
 	ResourceID string     `json:"resourceId"`
 	Key        string     `json:"key"`
 	Value      int        `json:"value"`
+	Version    int        `json:"version,omitempty"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+}
+
+type AlertRuleCreateDTO struct {
+	Resource        string `json:"resource"`
+	ResourceId      string `json:"resourceId"`
+	Key             string `json:"key"`
+	Operator        string `json:"operator"`
+	Threshold       int    `json:"threshold"`
+	WebhookURL      string `json:"webhookUrl"`
+	Secret          string `json:"secret"`
+	CooldownSeconds int    `json:"cooldownSeconds"`
+	Enabled         *bool  `json:"enabled"`
+}
+
+// AlertRuleUpdateDTO replaces a rule as a whole, secret included.
+type AlertRuleUpdateDTO struct {
+	Resource        string `json:"resource"`
+	ResourceId      string `json:"resourceId"`
+	Key             string `json:"key"`
+	Operator        string `json:"operator"`
+	Threshold       int    `json:"threshold"`
+	WebhookURL      string `json:"webhookUrl"`
+	Secret          string `json:"secret"`
+	CooldownSeconds int    `json:"cooldownSeconds"`
+	Enabled         *bool  `json:"enabled"`
+}
+
+// AlertRuleResponseDTO never exposes the signing secret.
+type AlertRuleResponseDTO struct {
+	ID              string     `json:"id"`
+	Resource        string     `json:"resource"`
+	ResourceID      string     `json:"resourceId,omitempty"`
+	Key             string     `json:"key"`
+	Operator        string     `json:"operator"`
+	Threshold       int        `json:"threshold"`
+	WebhookURL      string     `json:"webhookUrl"`
+	CooldownSeconds int        `json:"cooldownSeconds"`
+	Enabled         bool       `json:"enabled"`
+	CreatedAt       *time.Time `json:"createdAt,omitempty"`
+}
+
+// AuditEntryResponseDTO is one entry of GET /metrics/:id/audit. NewValue is
+// null for deletes.
+type AuditEntryResponseDTO struct {
+	ID         string     `json:"id"`
+	MetricID   string     `json:"metricId"`
+	Resource   string     `json:"resource"`
+	ResourceID string     `json:"resourceId"`
+	Key        string     `json:"key"`
+	Operation  string     `json:"operation"`
+	Actor      string     `json:"actor"`
+	OldValue   int        `json:"oldValue"`
+	NewValue   *int       `json:"newValue"`
 	CreatedAt  *time.Time `json:"createdAt,omitempty"`
 }
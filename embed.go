@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/hooks"
+)
+
+const (
+	includeQueryParam = "include"
+	includePrefix     = "metrics"
+)
+
+type includedMetricsKey struct{}
+
+type hiddenMetricKeysKey struct{}
+
+// Embedder attaches metric values to the responses of another gorest resource
+// (e.g. view counts on a post listing) with one batched query per page instead
+// of one /metrics call per item. Clients opt in per request with
+// ?include=metrics.views,metrics.likes, or ?include=metrics for every key.
+type Embedder struct {
+	db           database.Database
+	config       *Config
+	resourceType string
+}
+
+// Embedder returns an Embedder for metrics recorded against resourceType.
+func (p *MetricsPlugin) Embedder(resourceType string) *Embedder {
+	return &Embedder{
+		db:           p.db,
+		config:       &p.config,
+		resourceType: resourceType,
+	}
+}
+
+// ParseMetricsInclude extracts the metric keys requested through the include
+// query parameter. requested is false when the client did not ask for metrics;
+// a nil keys slice with requested true selects every key.
+func ParseMetricsInclude(c fiber.Ctx) (keys []string, requested bool) {
+	for k, v := range c.Request().URI().QueryArgs().All() {
+		if string(k) != includeQueryParam {
+			continue
+		}
+		for _, include := range splitList(string(v)) {
+			if include == includePrefix {
+				return nil, true
+			}
+			if key, ok := strings.CutPrefix(include, includePrefix+"."); ok && key != "" {
+				keys = append(keys, key)
+				requested = true
+			}
+		}
+	}
+	return keys, requested
+}
+
+// WithIncludedMetrics records the requested metric keys on ctx, where
+// EmbedHooks picks them up.
+func WithIncludedMetrics(ctx context.Context, keys []string) context.Context {
+	if keys == nil {
+		keys = []string{}
+	}
+	return context.WithValue(ctx, includedMetricsKey{}, keys)
+}
+
+// IncludedMetrics returns the metric keys recorded by WithIncludedMetrics. An
+// empty, non-nil slice selects every key.
+func IncludedMetrics(ctx context.Context) ([]string, bool) {
+	keys, ok := ctx.Value(includedMetricsKey{}).([]string)
+	return keys, ok
+}
+
+// Middleware parses ?include=metrics.* and stores the selection, along with
+// the caller's tenant and the private keys it may not read, on the request
+// context for EmbedHooks. Mount it on the routes of the embedding resource.
+func (e *Embedder) Middleware() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		keys, requested := ParseMetricsInclude(c)
+		if !requested {
+			return c.Next()
+		}
+		if len(keys) > MaxFilterValuesPerField {
+			return fiber.NewError(400, "too many metrics requested in include")
+		}
+		ctx, err := e.readContext(c, keys)
+		if err != nil {
+			return err
+		}
+		c.SetContext(WithIncludedMetrics(ctx, keys))
+		return c.Next()
+	}
+}
+
+// readContext authorizes the caller to read the requested keys and returns the
+// request context carrying its tenant and the private keys it may not read.
+func (e *Embedder) readContext(c fiber.Ctx, keys []string) (context.Context, error) {
+	if e.config == nil {
+		return c.Context(), nil
+	}
+	req := AuthorizationRequest{Operation: OperationRead, ResourceType: e.resourceType}
+	if len(keys) == 1 {
+		req.Key = keys[0]
+	}
+	if err := e.config.authorize(c, req); err != nil {
+		return nil, err
+	}
+	tenant, err := e.config.tenant(c)
+	if err != nil {
+		return nil, err
+	}
+	ctx := WithTenant(c.Context(), tenant)
+	return context.WithValue(ctx, hiddenMetricKeysKey{}, e.config.hiddenKeys(c)), nil
+}
+
+// Load fetches the given keys (all keys when empty) for every resource ID in a
+// single query, keyed by resource ID. Every ID gets an entry. Only metrics of
+// the tenant recorded on ctx by WithTenant are read, and private keys the
+// caller may not read, as resolved by Middleware or Embed, are left out.
+func (e *Embedder) Load(ctx context.Context, resourceIDs []string, keys []string) (map[string]map[string]int, error) {
+	if e.db == nil {
+		return nil, errNoDatabase
+	}
+	values, err := fetchMetricValues(ctx, e.db, TenantFromContext(ctx), e.resourceType, resourceIDs, keys)
+	if err != nil {
+		return nil, err
+	}
+	hidden, _ := ctx.Value(hiddenMetricKeysKey{}).([]string)
+	dropKeys(values, hidden)
+	return values, nil
+}
+
+// Embed attaches the metrics requested by ?include=metrics.* to items, for
+// handlers that build their own response DTOs. It is a no-op when the client
+// did not ask for metrics.
+func Embed[T any](c fiber.Ctx, e *Embedder, items []T, idOf func(T) string, set func(*T, map[string]int)) error {
+	keys, requested := ParseMetricsInclude(c)
+	if !requested || len(items) == 0 {
+		return nil
+	}
+	if len(keys) > MaxFilterValuesPerField {
+		return fiber.NewError(400, "too many metrics requested in include")
+	}
+
+	ctx, err := e.readContext(c, keys)
+	if err != nil {
+		return err
+	}
+	return embedInto(ctx, e, items, keys, idOf, set)
+}
+
+func embedInto[T any](ctx context.Context, e *Embedder, items []T, keys []string, idOf func(T) string, set func(*T, map[string]int)) error {
+	ids := make([]string, len(items))
+	for i := range items {
+		ids[i] = idOf(items[i])
+	}
+
+	values, err := e.Load(ctx, ids, keys)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		set(&items[i], values[ids[i]])
+	}
+	return nil
+}
+
+// EmbedHooks decorates the gorest CRUD hooks of another resource so GetAll and
+// GetByID load the metrics selected by Embedder.Middleware into each model in
+// one batched query per page. The model carries them in a field ignored by the
+// CRUD layer (db:"-"), which its ModelToResponseDTO then copies to the DTO:
+//
+//	hooks := metrics.NewEmbedHooks[Post](postHooks, mp.Embedder("post"),
+//		func(p Post) string { return p.Id },
+//		func(p *Post, m map[string]int) { p.Metrics = m })
+type EmbedHooks[T any] struct {
+	hooks.Hooks[T]
+	embedder *Embedder
+	idOf     func(T) string
+	set      func(*T, map[string]int)
+}
+
+func NewEmbedHooks[T any](inner hooks.Hooks[T], embedder *Embedder, idOf func(T) string, set func(*T, map[string]int)) *EmbedHooks[T] {
+	if inner == nil {
+		inner = hooks.NewNoOpHooks[T]()
+	}
+	return &EmbedHooks[T]{
+		Hooks:    inner,
+		embedder: embedder,
+		idOf:     idOf,
+		set:      set,
+	}
+}
+
+func (h *EmbedHooks[T]) SerializeOne(ctx context.Context, operation hooks.Operation, model *T) error {
+	if err := h.Hooks.SerializeOne(ctx, operation, model); err != nil {
+		return err
+	}
+
+	keys, ok := IncludedMetrics(ctx)
+	if !ok || operation != hooks.OperationGetByID || model == nil {
+		return nil
+	}
+
+	items := []T{*model}
+	if err := embedInto(ctx, h.embedder, items, keys, h.idOf, h.set); err != nil {
+		return err
+	}
+	*model = items[0]
+	return nil
+}
+
+func (h *EmbedHooks[T]) SerializeMany(ctx context.Context, operation hooks.Operation, models *[]T) error {
+	if err := h.Hooks.SerializeMany(ctx, operation, models); err != nil {
+		return err
+	}
+
+	keys, ok := IncludedMetrics(ctx)
+	if !ok || models == nil || len(*models) == 0 {
+		return nil
+	}
+	if operation != hooks.OperationGetAll && operation != hooks.OperationGetByID {
+		return nil
+	}
+
+	return embedInto(ctx, h.embedder, *models, keys, h.idOf, h.set)
+}
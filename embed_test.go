@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type embedPost struct {
+	Id      string         `db:"id"`
+	Title   string         `db:"title"`
+	Metrics map[string]int `db:"-"`
+}
+
+func (embedPost) TableName() string {
+	return "posts"
+}
+
+func TestParseMetricsInclude(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		wantKeys      []string
+		wantRequested bool
+	}{
+		{name: "not requested", query: "", wantKeys: nil, wantRequested: false},
+		{name: "other includes only", query: "?include=author", wantKeys: nil, wantRequested: false},
+		{name: "selected keys", query: "?include=author,metrics.views,metrics.likes", wantKeys: []string{"views", "likes"}, wantRequested: true},
+		{name: "all keys", query: "?include=metrics", wantKeys: nil, wantRequested: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/posts", func(c fiber.Ctx) error {
+				keys, requested := ParseMetricsInclude(c)
+				assert.Equal(t, tt.wantKeys, keys)
+				assert.Equal(t, tt.wantRequested, requested)
+				return nil
+			})
+			status, _ := doGet(t, app, "/posts"+tt.query)
+			assert.Equal(t, http.StatusOK, status)
+		})
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{db: db, config: DefaultConfig()}
+
+	first := uuid.New().String()
+	second := uuid.New().String()
+	insertMetric(t, db, first, "views")
+	insertMetric(t, db, first, "likes")
+
+	type postDTO struct {
+		ID      string         `json:"id"`
+		Metrics map[string]int `json:"metrics,omitempty"`
+	}
+
+	app := fiber.New()
+	app.Get("/posts", func(c fiber.Ctx) error {
+		items := []postDTO{{ID: first}, {ID: second}}
+		if err := Embed(c, p.Embedder("post"), items,
+			func(d postDTO) string { return d.ID },
+			func(d *postDTO, m map[string]int) { d.Metrics = m },
+		); err != nil {
+			return err
+		}
+		return c.JSON(items)
+	})
+
+	_, body := doGet(t, app, "/posts?include=metrics.views")
+
+	var got []postDTO
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, []postDTO{
+		{ID: first, Metrics: map[string]int{"views": 1}},
+		{ID: second, Metrics: nil},
+	}, got)
+}
+
+func TestEmbed_PrivateKeysAndAuthorization(t *testing.T) {
+	db := newTestDB(t)
+	config := DefaultConfig()
+	config.KeyVisibility = map[string]string{"revenue": VisibilityPrivate}
+	require.NoError(t, config.Validate())
+	p := &MetricsPlugin{db: db, config: config}
+
+	id := uuid.New().String()
+	insertMetric(t, db, id, "views")
+	insertMetric(t, db, id, "revenue")
+
+	handler := func(e *Embedder) fiber.Handler {
+		return func(c fiber.Ctx) error {
+			items := []map[string]int{nil}
+			if err := Embed(c, e, items,
+				func(map[string]int) string { return id },
+				func(d *map[string]int, m map[string]int) { *d = m },
+			); err != nil {
+				return err
+			}
+			return c.JSON(items[0])
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/anonymous", handler(p.Embedder("post")))
+	app.Get("/admin", withRoles("admin"), handler(p.Embedder("post")))
+
+	denied := &MetricsPlugin{db: db, config: config}
+	denied.config.Authorizer = AuthorizerFunc(func(_ fiber.Ctx, req AuthorizationRequest) error {
+		if req.Operation == OperationRead {
+			return fiber.NewError(http.StatusForbidden, "forbidden")
+		}
+		return nil
+	})
+	app.Get("/denied", handler(denied.Embedder("post")))
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		want       map[string]int
+	}{
+		{name: "private key hidden", target: "/anonymous?include=metrics", wantStatus: http.StatusOK, want: map[string]int{"views": 1}},
+		{name: "private key selected", target: "/anonymous?include=metrics.revenue", wantStatus: http.StatusOK, want: map[string]int{}},
+		{name: "private key readable by admin", target: "/admin?include=metrics", wantStatus: http.StatusOK, want: map[string]int{"views": 1, "revenue": 1}},
+		{name: "read denied", target: "/denied?include=metrics", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doGet(t, app, tt.target)
+			require.Equal(t, tt.wantStatus, status)
+			if tt.want == nil {
+				return
+			}
+			var got map[string]int
+			require.NoError(t, json.Unmarshal(body, &got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEmbedHooks_LoadsRequestedMetricsPerPage(t *testing.T) {
+	db := newCascadeTestDB(t)
+	p := &MetricsPlugin{db: db, config: DefaultConfig()}
+
+	first := uuid.New().String()
+	second := uuid.New().String()
+	for _, id := range []string{first, second} {
+		_, err := db.Exec(context.Background(), rebind(db, "INSERT INTO posts (id, title) VALUES (?, ?)"), id, "post")
+		require.NoError(t, err)
+	}
+	insertMetric(t, db, first, "views")
+	insertMetric(t, db, second, "likes")
+
+	posts := crud.NewWithHooks[embedPost](db, NewEmbedHooks[embedPost](nil, p.Embedder("post"),
+		func(p embedPost) string { return p.Id },
+		func(p *embedPost, m map[string]int) { p.Metrics = m },
+	))
+
+	// Without an include selection nothing is loaded.
+	page, err := posts.GetAllPaginated(context.Background(), crud.PaginationOptions{Limit: 10})
+	require.NoError(t, err)
+	for _, post := range page.Items {
+		assert.Nil(t, post.Metrics)
+	}
+
+	ctx := WithIncludedMetrics(context.Background(), []string{"views"})
+	page, err = posts.GetAllPaginated(ctx, crud.PaginationOptions{Limit: 10})
+	require.NoError(t, err)
+
+	got := map[string]map[string]int{}
+	for _, post := range page.Items {
+		got[post.Id] = post.Metrics
+	}
+	assert.Equal(t, map[string]map[string]int{
+		first:  {"views": 1},
+		second: {},
+	}, got)
+
+	one, err := posts.GetByID(WithIncludedMetrics(context.Background(), nil), second)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"likes": 1}, one.Metrics)
+}
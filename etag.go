@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+var (
+	errMetricModified     = fiber.NewError(fiber.StatusConflict, "metric was modified concurrently")
+	errPreconditionFailed = fiber.NewError(fiber.StatusPreconditionFailed, "metric does not match If-Match")
+)
+
+// etag returns the entity tag of m: its version, which every write bumps.
+func etag(m Metric) string {
+	return `"` + strconv.Itoa(m.Version) + `"`
+}
+
+// ifMatch checks an If-Match header against m. It reports whether the header
+// was sent and, if so, whether it lists m's tag or is "*". Weak tags never
+// match: If-Match uses the strong comparison.
+func ifMatch(header string, m Metric) (sent, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return false, false
+	}
+	if header == "*" {
+		return true, true
+	}
+
+	want := etag(m)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == want {
+			return true, true
+		}
+	}
+	return true, false
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatch(t *testing.T) {
+	m := Metric{Version: 3}
+
+	tests := []struct {
+		name     string
+		header   string
+		wantSent bool
+		wantOK   bool
+	}{
+		{name: "absent", header: "", wantSent: false, wantOK: false},
+		{name: "current", header: `"3"`, wantSent: true, wantOK: true},
+		{name: "stale", header: `"2"`, wantSent: true, wantOK: false},
+		{name: "any", header: "*", wantSent: true, wantOK: true},
+		{name: "list", header: `"1", "3"`, wantSent: true, wantOK: true},
+		{name: "weak", header: `W/"3"`, wantSent: true, wantOK: false},
+		{name: "unquoted", header: "3", wantSent: true, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent, ok := ifMatch(tt.header, m)
+			assert.Equal(t, tt.wantSent, sent)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
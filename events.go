@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nicolasbonnici/gorest/logger"
+)
+
+const defaultSubscriberBufferSize = 1024
+
+// Metric event types delivered to subscribers.
+const (
+	// EventMetricAccepted is emitted when POST /metrics accepts a metric,
+	// before the background writer has persisted it.
+	EventMetricAccepted = "metric.accepted"
+	// EventMetricPersisted is emitted once the background writer has stored
+	// the metric.
+	EventMetricPersisted = "metric.persisted"
+	// EventMetricUpdated is emitted after PUT /metrics/:id; Before and After
+	// hold the previous and new values.
+	EventMetricUpdated = "metric.updated"
+)
+
+// MetricEvent describes a change to a metric. Metric is the state after the
+// change; Before and After are only set on EventMetricUpdated.
+type MetricEvent struct {
+	Type       string    `json:"type"`
+	Metric     Metric    `json:"metric"`
+	Before     *int      `json:"before,omitempty"`
+	After      *int      `json:"after,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// Subscriber receives metric events. Each subscriber is called sequentially on
+// its own goroutine, never on the request goroutine, so it may block without
+// slowing the API down; events that do not fit in its buffer are dropped.
+type Subscriber interface {
+	HandleMetricEvent(event MetricEvent)
+}
+
+// SubscriberFunc adapts a function to the Subscriber interface.
+type SubscriberFunc func(event MetricEvent)
+
+func (f SubscriberFunc) HandleMetricEvent(event MetricEvent) {
+	f(event)
+}
+
+type eventSubscription struct {
+	events chan MetricEvent
+	once   sync.Once
+}
+
+func (s *eventSubscription) stop() {
+	s.once.Do(func() { close(s.events) })
+}
+
+// eventBus fans metric events out to the subscribers registered through
+// MetricsPlugin.Subscribe. Its zero value is ready to use so subscribers can
+// register before SetupEndpoints.
+type eventBus struct {
+	mu     sync.RWMutex
+	subs   map[*eventSubscription]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Subscribe registers sub for every metric event until the returned function
+// is called or the plugin is closed.
+func (p *MetricsPlugin) Subscribe(sub Subscriber) (unsubscribe func()) {
+	bufferSize := p.config.SubscriberBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriberBufferSize
+	}
+	return p.events.subscribe(sub, bufferSize)
+}
+
+func (b *eventBus) subscribe(sub Subscriber, bufferSize int) func() {
+	s := &eventSubscription{
+		events: make(chan MetricEvent, bufferSize),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return func() {}
+	}
+	if b.subs == nil {
+		b.subs = make(map[*eventSubscription]struct{})
+	}
+	b.subs[s] = struct{}{}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for event := range s.events {
+			sub.HandleMetricEvent(event)
+		}
+	}()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[s]; ok {
+			delete(b.subs, s)
+			s.stop()
+		}
+	}
+}
+
+// publish hands events to every subscriber without blocking. A nil bus
+// discards them.
+func (b *eventBus) publish(events ...MetricEvent) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		for _, event := range events {
+			select {
+			case s.events <- event:
+			default:
+				logger.Log.Warn("metrics: subscriber too slow, dropping event",
+					"type", event.Type,
+					"resource", event.Metric.Resource,
+					"key", event.Metric.Key,
+				)
+			}
+		}
+	}
+}
+
+// publishPersisted is a batchWriter flush listener.
+func (b *eventBus) publishPersisted(batch []Metric) {
+	now := time.Now().UTC()
+	events := make([]MetricEvent, len(batch))
+	for i, m := range batch {
+		events[i] = MetricEvent{Type: EventMetricPersisted, Metric: m, OccurredAt: now}
+	}
+	b.publish(events...)
+}
+
+// close stops accepting events and waits for subscribers to drain what they
+// have already received.
+func (b *eventBus) close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		s.stop()
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
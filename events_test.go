@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSubscriber struct {
+	mu     sync.Mutex
+	events []MetricEvent
+	seen   chan struct{}
+}
+
+func newRecordingSubscriber() *recordingSubscriber {
+	return &recordingSubscriber{seen: make(chan struct{}, 64)}
+}
+
+func (s *recordingSubscriber) HandleMetricEvent(event MetricEvent) {
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+	s.seen <- struct{}{}
+}
+
+func (s *recordingSubscriber) wait(t *testing.T, n int) []MetricEvent {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.seen:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %d events, got %d", n, i)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MetricEvent(nil), s.events...)
+}
+
+func TestMetricsPlugin_SubscribeReceivesLifecycleEvents(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{config: DefaultConfig()}
+	sub := newRecordingSubscriber()
+	p.Subscribe(sub)
+
+	writer := newBatchWriter(db, batchWriterOptions{
+		flushInterval:  time.Hour,
+		flushListeners: []func([]Metric){p.events.publishPersisted},
+	})
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(app, db, &p.config, writer, &p.events, nil, nil, nil)
+
+	resourceID := uuid.New().String()
+	status, body := doJSON(t, app, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "views", Value: 3,
+	})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var created MetricResponseDTO
+	require.NoError(t, json.Unmarshal(body, &created))
+
+	require.NoError(t, writer.shutdown(context.Background()))
+
+	status, body = doJSON(t, app, http.MethodPut, "/metrics/"+created.ID, MetricUpdateDTO{Value: 8})
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	events := sub.wait(t, 3)
+	require.NoError(t, p.events.close(context.Background()))
+
+	assert.Equal(t, EventMetricAccepted, events[0].Type)
+	assert.Equal(t, created.ID, events[0].Metric.Id)
+	assert.Nil(t, events[0].Before)
+
+	assert.Equal(t, EventMetricPersisted, events[1].Type)
+	assert.Equal(t, created.ID, events[1].Metric.Id)
+
+	updated := events[2]
+	assert.Equal(t, EventMetricUpdated, updated.Type)
+	require.NotNil(t, updated.Before)
+	require.NotNil(t, updated.After)
+	assert.Equal(t, 3, *updated.Before)
+	assert.Equal(t, 8, *updated.After)
+	assert.Equal(t, resourceID, updated.Metric.ResourceId)
+	assert.Equal(t, "views", updated.Metric.Key)
+
+	// The update only touches the value column.
+	var storedResourceID, storedKey string
+	var storedValue int
+	require.NoError(t, db.QueryRow(context.Background(),
+		rebind(db, "SELECT resource_id, name, value FROM metrics WHERE id = ?"), created.ID,
+	).Scan(&storedResourceID, &storedKey, &storedValue))
+	assert.Equal(t, resourceID, storedResourceID)
+	assert.Equal(t, "views", storedKey)
+	assert.Equal(t, 8, storedValue)
+}
+
+func TestMetricsPlugin_Unsubscribe(t *testing.T) {
+	p := &MetricsPlugin{}
+	sub := newRecordingSubscriber()
+	unsubscribe := p.Subscribe(sub)
+
+	p.events.publish(MetricEvent{Type: EventMetricAccepted, Metric: sampleMetric()})
+	sub.wait(t, 1)
+
+	unsubscribe()
+	unsubscribe()
+	p.events.publish(MetricEvent{Type: EventMetricAccepted, Metric: sampleMetric()})
+
+	require.NoError(t, p.events.close(context.Background()))
+	assert.Len(t, sub.events, 1)
+}
+
+func TestEventBus_SlowSubscriberDoesNotBlockPublisher(t *testing.T) {
+	var bus eventBus
+	release := make(chan struct{})
+	var handled int
+	bus.subscribe(SubscriberFunc(func(MetricEvent) {
+		<-release
+		handled++
+	}), 2)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bus.publish(MetricEvent{Type: EventMetricAccepted, Metric: sampleMetric()})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	close(release)
+	require.NoError(t, bus.close(context.Background()))
+	assert.LessOrEqual(t, handled, 3, "events beyond the buffer are dropped")
+}
+
+func TestEventBus_SubscribeAfterCloseIsNoop(t *testing.T) {
+	var bus eventBus
+	require.NoError(t, bus.close(context.Background()))
+
+	unsubscribe := bus.subscribe(SubscriberFunc(func(MetricEvent) {
+		t.Error("closed bus must not deliver events")
+	}), 1)
+	bus.publish(MetricEvent{Type: EventMetricAccepted})
+	unsubscribe()
+}
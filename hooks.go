@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v3"
@@ -20,40 +21,158 @@ func NewMetricHooks(config *Config) *MetricHooks {
 }
 
 func (h *MetricHooks) CreateHook(c fiber.Ctx, dto MetricCreateDTO, model *Metric) error {
-	if !h.config.IsAllowedType(dto.Resource) {
-		return fiber.NewError(400, "resource type is not allowed")
+	key, err := h.validateTarget(dto.Resource, dto.ResourceId, dto.Key)
+	if err != nil {
+		return err
 	}
 
-	if _, err := uuid.Parse(dto.ResourceId); err != nil {
-		return fiber.NewError(400, "resourceId must be a valid UUID")
+	if err := h.validateValue(dto.Value); err != nil {
+		return err
 	}
 
-	key := strings.TrimSpace(dto.Key)
-	if key == "" {
-		return fiber.NewError(400, "key cannot be empty")
+	if err := h.validateVisitor(key, dto.Visitor, dto.Value); err != nil {
+		return err
 	}
 
-	if len(key) > h.config.MaxKeyLength {
-		return fiber.NewError(400, "key exceeds maximum length")
+	if len(dto.Actor) > MaxActorLength {
+		return fiber.NewError(400, "actor exceeds maximum length")
 	}
 
-	if h.config.OnlyPositiveValues && dto.Value < 0 {
-		return fiber.NewError(400, "value must be positive")
+	tenant, err := h.config.tenant(c)
+	if err != nil {
+		return err
 	}
 
 	model.Key = key
+	model.Tenant = tenant
+
+	return nil
+}
+
+// validateTarget applies the allowlist checks shared by every entry point that
+// addresses a (resource, resourceId, key) tuple and returns the normalised key.
+func (h *MetricHooks) validateTarget(resourceType, resourceID, key string) (string, error) {
+	if !h.config.IsAllowedType(resourceType) {
+		return "", fiber.NewError(400, "resource type is not allowed")
+	}
+
+	if err := h.validateResourceID(resourceType, resourceID); err != nil {
+		return "", err
+	}
+
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fiber.NewError(400, "key cannot be empty")
+	}
+
+	if len(key) > h.config.MaxKeyLength {
+		return "", fiber.NewError(400, "key exceeds maximum length")
+	}
+
+	return key, nil
+}
+
+// validateResourceID checks id against the format configured for the resource
+// type, defaulting to UUID.
+func (h *MetricHooks) validateResourceID(resourceType, id string) error {
+	format := h.config.ResourceIDFormat(resourceType)
+
+	switch format.Type {
+	case IDFormatInt:
+		// Only the canonical form is accepted, so "+7" and "007" cannot
+		// record metrics apart from "7".
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || strconv.FormatInt(n, 10) != id {
+			return fiber.NewError(400, "resourceId must be a valid integer")
+		}
+	case IDFormatString:
+		if id == "" || len(id) > MaxResourceIDLength {
+			return fiber.NewError(400, "resourceId must be between 1 and 255 characters")
+		}
+		if format.Pattern == "" {
+			return nil
+		}
+		re, err := h.config.resourceIDPattern(resourceType, format)
+		if err != nil {
+			return fiber.NewError(500, "invalid resourceId pattern")
+		}
+		if !re.MatchString(id) {
+			return fiber.NewError(400, "resourceId does not match the expected format")
+		}
+	default:
+		// uuid.Parse also accepts uppercase, braced, urn:uuid: and unhyphenated
+		// forms; only the canonical one is accepted so each resource has one ID.
+		parsed, err := uuid.Parse(id)
+		if err != nil || parsed.String() != id {
+			return fiber.NewError(400, "resourceId must be a valid UUID")
+		}
+	}
 
 	return nil
 }
 
 func (h *MetricHooks) UpdateHook(c fiber.Ctx, dto MetricUpdateDTO, model *Metric) error {
-	if h.config.OnlyPositiveValues && dto.Value < 0 {
+	return h.validateValue(dto.Value)
+}
+
+func (h *MetricHooks) validateValue(value int) error {
+	if h.config.OnlyPositiveValues && value < 0 {
 		return fiber.NewError(400, "value must be positive")
 	}
 
 	return nil
 }
 
+// validateVisitor requires a visitor, and no value, for unique keys and
+// rejects visitors for every other key.
+func (h *MetricHooks) validateVisitor(key, visitor string, value int) error {
+	if !h.config.IsUniqueKey(key) {
+		if visitor != "" {
+			return fiber.NewError(400, "visitor is only accepted for unique metrics")
+		}
+		return nil
+	}
+
+	if strings.TrimSpace(visitor) == "" {
+		return fiber.NewError(400, "visitor is required for unique metrics")
+	}
+	if len(visitor) > MaxVisitorLength {
+		return fiber.NewError(400, "visitor exceeds maximum length")
+	}
+	if value != 0 {
+		return fiber.NewError(400, "value cannot be set on unique metrics")
+	}
+
+	return nil
+}
+
+// GetAllHook scopes the listing to the caller's tenant and leaves out deleted
+// metrics, unless ?withDeleted=true is passed by a caller authorized for
+// OperationReadDeleted, and the private keys the caller may not read.
 func (h *MetricHooks) GetAllHook(c fiber.Ctx, conditions *[]query.Condition, orderBy *[]crud.OrderByClause) error {
+	tenant, err := h.config.tenant(c)
+	if err != nil {
+		return err
+	}
+	*conditions = append(*conditions, query.Eq("tenant", tenant))
+
+	if c.Query("withDeleted") == "true" {
+		if err := h.config.authorize(c, AuthorizationRequest{Operation: OperationReadDeleted}); err != nil {
+			return err
+		}
+	} else {
+		*conditions = append(*conditions, query.IsNull("deleted_at"))
+	}
+
+	hidden := h.config.hiddenKeys(c)
+	if len(hidden) == 0 {
+		return nil
+	}
+
+	values := make([]any, len(hidden))
+	for i, key := range hidden {
+		values[i] = key
+	}
+	*conditions = append(*conditions, query.NotIn("name", values...))
 	return nil
 }
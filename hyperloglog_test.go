@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSketch(from, to int) *hyperLogLog {
+	h := new(hyperLogLog)
+	for i := from; i < to; i++ {
+		h.add(hashVisitor(fmt.Sprintf("visitor-%d", i)))
+	}
+	return h
+}
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	tests := []struct {
+		distinct  int
+		tolerance float64
+	}{
+		{distinct: 0, tolerance: 0},
+		{distinct: 1, tolerance: 0},
+		{distinct: 10, tolerance: 0},
+		{distinct: 1000, tolerance: 0.05},
+		{distinct: 20000, tolerance: 0.05},
+		{distinct: 200000, tolerance: 0.05},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.distinct), func(t *testing.T) {
+			h := newTestSketch(0, tt.distinct)
+			// Repeated visitors do not change the count.
+			h.merge(newTestSketch(0, tt.distinct/2))
+
+			assert.InEpsilon(t, float64(tt.distinct)+1, float64(h.estimate())+1, tt.tolerance+1e-9)
+		})
+	}
+}
+
+func TestHyperLogLog_MergeCountsTheUnion(t *testing.T) {
+	monday := newTestSketch(0, 6000)
+	tuesday := newTestSketch(4000, 10000)
+
+	monday.merge(tuesday)
+
+	assert.InEpsilon(t, 10000, float64(monday.estimate()), 0.05)
+	assert.InEpsilon(t, 6000, float64(tuesday.estimate()), 0.05, "merge leaves its argument untouched")
+}
+
+func TestHyperLogLog_Binary(t *testing.T) {
+	h := newTestSketch(0, 500)
+
+	data, err := h.MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, data, 2+hllRegisters)
+
+	var decoded hyperLogLog
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, h.estimate(), decoded.estimate())
+
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data[:100]), errInvalidSketch)
+	data[0] = hllVersion + 1
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data), errInvalidSketch)
+}
+
+func TestHashVisitor_IsStable(t *testing.T) {
+	// Persisted sketches depend on these values never changing.
+	assert.Equal(t, hashVisitor("user-42"), hashVisitor("user-42"))
+	assert.NotEqual(t, hashVisitor("user-42"), hashVisitor("user-43"))
+	assert.Equal(t, uint64(0xa39532c7ab051e8d), hashVisitor("user-42"))
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	c.set("a", 1)
+	c.set("b", 2)
+
+	got, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, got)
+
+	// b is now the least recently used entry.
+	c.set("c", 3)
+	_, ok = c.get("b")
+	assert.False(t, ok)
+
+	c.set("a", 10)
+	got, _ = c.get("a")
+	assert.Equal(t, 10, got)
+	got, _ = c.get("c")
+	assert.Equal(t, 3, got)
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"math/rand/v2"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/logger"
+)
+
+// recordRoute increments the metric mapped to the route c matched, if any,
+// when the response status qualifies and the request is sampled.
+func (p *MetricsPlugin) recordRoute(c fiber.Ctx) {
+	if len(p.config.routeTargets) == 0 {
+		return
+	}
+
+	route := c.Route()
+	target, ok := p.config.routeTargets[route.Method+" "+route.Path]
+	if !ok {
+		return
+	}
+
+	status := c.Response().StatusCode()
+	if status < 200 || status > 299 {
+		return
+	}
+	if target.statuses != nil && !target.statuses[status] {
+		return
+	}
+	if target.rate < 1 && rand.Float64() >= target.rate {
+		return
+	}
+
+	rec := p.Recorder()
+	if rec == nil {
+		return
+	}
+
+	// Params point into the request buffer, which is reused once the handler
+	// returns; the writer keeps the ID past that.
+	resourceID := strings.Clone(c.Params(target.param))
+	tenant, err := p.config.tenant(c)
+	if err != nil {
+		logger.Log.Debug("metrics: route metric not recorded",
+			"error", err,
+			"route", route.Path,
+			"resourceId", resourceID,
+		)
+		return
+	}
+	if err := rec.WithTenant(tenant).Increment(target.resource, resourceID, target.key, target.weight); err != nil {
+		logger.Log.Debug("metrics: route metric not recorded",
+			"error", err,
+			"route", route.Path,
+			"resourceId", resourceID,
+		)
+	}
+}
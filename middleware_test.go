@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouteMetricsApp(t *testing.T, routeMetrics map[string]interface{}) (*fiber.App, *MetricsPlugin) {
+	t.Helper()
+
+	p := &MetricsPlugin{}
+	require.NoError(t, p.Initialize(map[string]interface{}{
+		"database":      newTestDB(t),
+		"route_metrics": routeMetrics,
+	}))
+
+	app := fiber.New()
+	app.Use(p.Handler())
+	app.Get("/posts/:id", func(c fiber.Ctx) error {
+		if c.Query("missing") != "" {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		if c.Query("accepted") != "" {
+			return c.SendStatus(fiber.StatusAccepted)
+		}
+		return c.SendString("post")
+	})
+	app.Get("/posts/:id/comments", func(c fiber.Ctx) error {
+		return fiber.ErrInternalServerError
+	})
+	require.NoError(t, p.SetupEndpoints(app))
+
+	return app, p
+}
+
+func TestMetricsPlugin_HandlerRecordsRouteMetrics(t *testing.T) {
+	app, p := newRouteMetricsApp(t, map[string]interface{}{
+		"GET /posts/:id": map[string]interface{}{
+			"metric":   "post/:id/views",
+			"statuses": []interface{}{200},
+		},
+		"GET /posts/:id/comments": "post/:id/comment_views",
+	})
+
+	id := uuid.New().String()
+	for _, target := range []string{
+		"/posts/" + id,
+		"/posts/" + id,
+		"/posts/" + id + "?missing=1",
+		"/posts/" + id + "?accepted=1",
+		"/posts/" + id + "/comments",
+		"/posts/not-a-uuid",
+	} {
+		doGet(t, app, target)
+	}
+
+	ctx := context.Background()
+	require.NoError(t, p.Close(ctx))
+
+	views, err := fetchMetric(ctx, p.db, Metric{Resource: "post", ResourceId: id, Key: "views"})
+	require.NoError(t, err)
+	require.NotNil(t, views)
+	assert.Equal(t, 2, views.Value, "only 200 responses are counted")
+
+	comments, err := fetchMetric(ctx, p.db, Metric{Resource: "post", ResourceId: id, Key: "comment_views"})
+	require.NoError(t, err)
+	assert.Nil(t, comments, "failed requests are not counted")
+	assert.Equal(t, 1, countMetrics(t, p.db))
+}
+
+func TestMetricsPlugin_HandlerSamplesRequests(t *testing.T) {
+	app, p := newRouteMetricsApp(t, map[string]interface{}{
+		"GET /posts/:id": map[string]interface{}{
+			"metric":      "post/:id/views",
+			"sample_rate": 0.25,
+		},
+	})
+
+	id := uuid.New().String()
+	const requests = 400
+	for i := 0; i < requests; i++ {
+		status, _ := doGet(t, app, "/posts/"+id)
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	ctx := context.Background()
+	require.NoError(t, p.Close(ctx))
+
+	views, err := fetchMetric(ctx, p.db, Metric{Resource: "post", ResourceId: id, Key: "views"})
+	require.NoError(t, err)
+	require.NotNil(t, views)
+	assert.Zero(t, views.Value%4, "each sampled request weighs 1/sample_rate")
+	assert.InDelta(t, requests, views.Value, requests/2)
+}
+
+func TestMetricsPlugin_HandlerWithoutRouteMetrics(t *testing.T) {
+	app, p := newRouteMetricsApp(t, nil)
+
+	status, _ := doGet(t, app, "/posts/"+uuid.New().String())
+	assert.Equal(t, http.StatusOK, status)
+
+	require.NoError(t, p.Close(context.Background()))
+	assert.Equal(t, 0, countMetrics(t, p.db))
+}
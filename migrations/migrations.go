@@ -2,6 +2,7 @@ package migrations
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/migrations"
@@ -68,12 +69,12 @@ func GetMigrations() migrations.MigrationSource {
 
 			if db.DriverName() == "sqlite" {
 				if err := migrations.SQL(ctx, db, migrations.DialectSQL{
-					SQLite: `CREATE INDEX IF NOT EXISTS idx_metrics_resource ON metrics(resource, resource_id, key)`,
+					SQLite: `CREATE INDEX IF NOT EXISTS idx_metrics_resource ON metrics(resource, resource_id, name)`,
 				}); err != nil {
 					return err
 				}
 				if err := migrations.SQL(ctx, db, migrations.DialectSQL{
-					SQLite: `CREATE INDEX IF NOT EXISTS idx_metrics_key ON metrics(key, created_at)`,
+					SQLite: `CREATE INDEX IF NOT EXISTS idx_metrics_key ON metrics(name, created_at)`,
 				}); err != nil {
 					return err
 				}
@@ -95,5 +96,774 @@ func GetMigrations() migrations.MigrationSource {
 		},
 	)
 
+	// resource_id started out as UUID/CHAR(36); widen it to a string column so
+	// resource types with integer or slug IDs can be tracked. Existing UUIDs are
+	// kept as their canonical text form. SQLite already stores it as TEXT.
+	builder.Add(
+		"20260301000000000",
+		"widen_metrics_resource_id",
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() == "sqlite" {
+				return nil
+			}
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE metrics ALTER COLUMN resource_id TYPE VARCHAR(255) USING resource_id::text`,
+				MySQL:    `ALTER TABLE metrics MODIFY resource_id VARCHAR(255) NOT NULL`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() == "sqlite" {
+				return nil
+			}
+			// Fails if non-UUID IDs have been stored since the upgrade, which is
+			// preferable to silently truncating them.
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE metrics ALTER COLUMN resource_id TYPE UUID USING resource_id::uuid`,
+				MySQL:    `ALTER TABLE metrics MODIFY resource_id CHAR(36) NOT NULL`,
+			})
+		},
+	)
+
+	// Metrics of deleted resources are moved here when on_resource_delete is
+	// set to archive.
+	builder.Add(
+		"20260302000000000",
+		"create_metrics_archive_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS metrics_archive (
+					id UUID PRIMARY KEY,
+					resource TEXT NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					value INTEGER NOT NULL DEFAULT 0,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					archived_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS metrics_archive (
+					id CHAR(36) PRIMARY KEY,
+					resource VARCHAR(255) NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					value INT NOT NULL DEFAULT 0,
+					created_at TIMESTAMP NOT NULL,
+					archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_metrics_archive_resource (resource, resource_id)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS metrics_archive (
+					id TEXT PRIMARY KEY,
+					resource TEXT NOT NULL,
+					resource_id TEXT NOT NULL,
+					name TEXT NOT NULL,
+					value INTEGER NOT NULL DEFAULT 0,
+					created_at TEXT NOT NULL,
+					archived_at TEXT NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				return migrations.CreateIndex(ctx, db, "idx_metrics_archive_resource", "metrics_archive", "resource, resource_id")
+			}
+
+			return nil
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				_ = migrations.DropIndex(ctx, db, "idx_metrics_archive_resource", "metrics_archive")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "metrics_archive")
+		},
+	)
+
+	// Threshold alert rules managed through /metrics/alerts. An empty
+	// resource_id matches every resource of the type.
+	builder.Add(
+		"20260303000000000",
+		"create_metric_alerts_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS metric_alerts (
+					id UUID PRIMARY KEY,
+					resource TEXT NOT NULL,
+					resource_id VARCHAR(255) NOT NULL DEFAULT '',
+					name VARCHAR(255) NOT NULL,
+					operator VARCHAR(8) NOT NULL,
+					threshold INTEGER NOT NULL,
+					webhook_url TEXT NOT NULL,
+					secret TEXT NOT NULL,
+					cooldown_seconds INTEGER NOT NULL,
+					enabled BOOLEAN NOT NULL DEFAULT TRUE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS metric_alerts (
+					id CHAR(36) PRIMARY KEY,
+					resource VARCHAR(255) NOT NULL,
+					resource_id VARCHAR(255) NOT NULL DEFAULT '',
+					name VARCHAR(255) NOT NULL,
+					operator VARCHAR(8) NOT NULL,
+					threshold INT NOT NULL,
+					webhook_url TEXT NOT NULL,
+					secret TEXT NOT NULL,
+					cooldown_seconds INT NOT NULL,
+					enabled BOOLEAN NOT NULL DEFAULT TRUE,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_metric_alerts_target (resource, name)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS metric_alerts (
+					id TEXT PRIMARY KEY,
+					resource TEXT NOT NULL,
+					resource_id TEXT NOT NULL DEFAULT '',
+					name TEXT NOT NULL,
+					operator TEXT NOT NULL,
+					threshold INTEGER NOT NULL,
+					webhook_url TEXT NOT NULL,
+					secret TEXT NOT NULL,
+					cooldown_seconds INTEGER NOT NULL,
+					enabled BOOLEAN NOT NULL DEFAULT 1,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				return migrations.CreateIndex(ctx, db, "idx_metric_alerts_target", "metric_alerts", "resource, name")
+			}
+
+			return nil
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				_ = migrations.DropIndex(ctx, db, "idx_metric_alerts_target", "metric_alerts")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "metric_alerts")
+		},
+	)
+
+	// One row per milestone reached by a metric; the primary key guarantees each
+	// milestone is announced once per resource even across instances.
+	builder.Add(
+		"20260304000000000",
+		"create_metric_milestones_table",
+		func(ctx context.Context, db database.Database) error {
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS metric_milestones (
+					resource TEXT NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					milestone INTEGER NOT NULL,
+					reached_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (resource, resource_id, name, milestone)
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS metric_milestones (
+					resource VARCHAR(255) NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					milestone INT NOT NULL,
+					reached_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (resource, resource_id, name, milestone)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS metric_milestones (
+					resource TEXT NOT NULL,
+					resource_id TEXT NOT NULL,
+					name TEXT NOT NULL,
+					milestone INTEGER NOT NULL,
+					reached_at TEXT NOT NULL DEFAULT (datetime('now')),
+					PRIMARY KEY (resource, resource_id, name, milestone)
+				)`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return migrations.DropTableIfExists(ctx, db, "metric_milestones")
+		},
+	)
+
+	// HyperLogLog sketches of unique metrics: one row per day bucket
+	// (YYYY-MM-DD) plus the all-time bucket "all". The bucket column is ASCII
+	// so the MySQL primary key stays within InnoDB's 3072-byte limit.
+	builder.Add(
+		"20260305000000000",
+		"create_metric_sketches_table",
+		func(ctx context.Context, db database.Database) error {
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS metric_sketches (
+					resource TEXT NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					bucket VARCHAR(10) NOT NULL,
+					sketch BYTEA NOT NULL,
+					PRIMARY KEY (resource, resource_id, name, bucket)
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS metric_sketches (
+					resource VARCHAR(255) NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					bucket VARCHAR(10) CHARACTER SET ascii NOT NULL,
+					sketch BLOB NOT NULL,
+					PRIMARY KEY (resource, resource_id, name, bucket)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS metric_sketches (
+					resource TEXT NOT NULL,
+					resource_id TEXT NOT NULL,
+					name TEXT NOT NULL,
+					bucket TEXT NOT NULL,
+					sketch BLOB NOT NULL,
+					PRIMARY KEY (resource, resource_id, name, bucket)
+				)`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return migrations.DropTableIfExists(ctx, db, "metric_sketches")
+		},
+	)
+
+	// Open dedup windows shared by every instance when dedup_store is
+	// database. dedup_key is the hex SHA-256 of the metric tuple and actor;
+	// expires_at is in Unix milliseconds.
+	builder.Add(
+		"20260306000000000",
+		"create_metric_dedup_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS metric_dedup (
+					dedup_key CHAR(64) PRIMARY KEY,
+					expires_at BIGINT NOT NULL
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS metric_dedup (
+					dedup_key CHAR(64) CHARACTER SET ascii PRIMARY KEY,
+					expires_at BIGINT NOT NULL,
+					INDEX idx_metric_dedup_expires_at (expires_at)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS metric_dedup (
+					dedup_key TEXT PRIMARY KEY,
+					expires_at INTEGER NOT NULL
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				return migrations.CreateIndex(ctx, db, "idx_metric_dedup_expires_at", "metric_dedup", "expires_at")
+			}
+
+			return nil
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				_ = migrations.DropIndex(ctx, db, "idx_metric_dedup_expires_at", "metric_dedup")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "metric_dedup")
+		},
+	)
+
+	// Metrics, their sketches and milestones gain a tenant; rows recorded
+	// before multi-tenancy belong to the default tenant ''. The tenant leads
+	// the unique constraints so each tenant has its own (resource, resource_id,
+	// name) space. On MySQL tenant and resource are ASCII so the keys stay
+	// within InnoDB's 3072-byte limit; SQLite cannot alter constraints, so its
+	// tables are rebuilt. Rolling back fails once two tenants share a tuple,
+	// which is preferable to silently merging their metrics.
+	builder.Add(
+		"20260307000000000",
+		"add_tenant_to_metrics",
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`ALTER TABLE metrics ADD COLUMN tenant VARCHAR(255) NOT NULL DEFAULT ''`,
+					`ALTER TABLE metrics DROP CONSTRAINT unique_resource_metric`,
+					`ALTER TABLE metrics ADD CONSTRAINT unique_resource_metric UNIQUE (tenant, resource, resource_id, name)`,
+					`ALTER TABLE metrics_archive ADD COLUMN tenant VARCHAR(255) NOT NULL DEFAULT ''`,
+					`ALTER TABLE metric_sketches ADD COLUMN tenant VARCHAR(255) NOT NULL DEFAULT ''`,
+					`ALTER TABLE metric_sketches DROP CONSTRAINT metric_sketches_pkey`,
+					`ALTER TABLE metric_sketches ADD PRIMARY KEY (tenant, resource, resource_id, name, bucket)`,
+					`ALTER TABLE metric_milestones ADD COLUMN tenant VARCHAR(255) NOT NULL DEFAULT ''`,
+					`ALTER TABLE metric_milestones DROP CONSTRAINT metric_milestones_pkey`,
+					`ALTER TABLE metric_milestones ADD PRIMARY KEY (tenant, resource, resource_id, name, milestone)`,
+				},
+				"mysql": {
+					`ALTER TABLE metrics
+						ADD COLUMN tenant VARCHAR(255) CHARACTER SET ascii NOT NULL DEFAULT '',
+						MODIFY resource VARCHAR(255) CHARACTER SET ascii NOT NULL,
+						DROP INDEX unique_resource_metric,
+						ADD UNIQUE KEY unique_resource_metric (tenant, resource, resource_id, ` + "`key`" + `)`,
+					`ALTER TABLE metrics_archive ADD COLUMN tenant VARCHAR(255) CHARACTER SET ascii NOT NULL DEFAULT ''`,
+					`ALTER TABLE metric_sketches
+						ADD COLUMN tenant VARCHAR(255) CHARACTER SET ascii NOT NULL DEFAULT '',
+						MODIFY resource VARCHAR(255) CHARACTER SET ascii NOT NULL,
+						DROP PRIMARY KEY,
+						ADD PRIMARY KEY (tenant, resource, resource_id, name, bucket)`,
+					`ALTER TABLE metric_milestones
+						ADD COLUMN tenant VARCHAR(255) CHARACTER SET ascii NOT NULL DEFAULT '',
+						MODIFY resource VARCHAR(255) CHARACTER SET ascii NOT NULL,
+						DROP PRIMARY KEY,
+						ADD PRIMARY KEY (tenant, resource, resource_id, name, milestone)`,
+				},
+				"sqlite": {
+					`CREATE TABLE metrics_rebuild (
+						id TEXT PRIMARY KEY,
+						tenant TEXT NOT NULL DEFAULT '',
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						value INTEGER NOT NULL DEFAULT 0,
+						created_at TEXT NOT NULL DEFAULT (datetime('now')),
+						UNIQUE (tenant, resource, resource_id, name)
+					)`,
+					`INSERT INTO metrics_rebuild (id, resource, resource_id, name, value, created_at)
+						SELECT id, resource, resource_id, name, value, created_at FROM metrics`,
+					`DROP TABLE metrics`,
+					`ALTER TABLE metrics_rebuild RENAME TO metrics`,
+					`CREATE INDEX IF NOT EXISTS idx_metrics_resource ON metrics(resource, resource_id, name)`,
+					`CREATE INDEX IF NOT EXISTS idx_metrics_key ON metrics(name, created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_metrics_resource_id ON metrics(resource_id)`,
+					`ALTER TABLE metrics_archive ADD COLUMN tenant TEXT NOT NULL DEFAULT ''`,
+					`CREATE TABLE metric_sketches_rebuild (
+						tenant TEXT NOT NULL DEFAULT '',
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						bucket TEXT NOT NULL,
+						sketch BLOB NOT NULL,
+						PRIMARY KEY (tenant, resource, resource_id, name, bucket)
+					)`,
+					`INSERT INTO metric_sketches_rebuild (resource, resource_id, name, bucket, sketch)
+						SELECT resource, resource_id, name, bucket, sketch FROM metric_sketches`,
+					`DROP TABLE metric_sketches`,
+					`ALTER TABLE metric_sketches_rebuild RENAME TO metric_sketches`,
+					`CREATE TABLE metric_milestones_rebuild (
+						tenant TEXT NOT NULL DEFAULT '',
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						milestone INTEGER NOT NULL,
+						reached_at TEXT NOT NULL DEFAULT (datetime('now')),
+						PRIMARY KEY (tenant, resource, resource_id, name, milestone)
+					)`,
+					`INSERT INTO metric_milestones_rebuild (resource, resource_id, name, milestone, reached_at)
+						SELECT resource, resource_id, name, milestone, reached_at FROM metric_milestones`,
+					`DROP TABLE metric_milestones`,
+					`ALTER TABLE metric_milestones_rebuild RENAME TO metric_milestones`,
+				},
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`ALTER TABLE metric_milestones DROP CONSTRAINT metric_milestones_pkey`,
+					`ALTER TABLE metric_milestones ADD PRIMARY KEY (resource, resource_id, name, milestone)`,
+					`ALTER TABLE metric_milestones DROP COLUMN tenant`,
+					`ALTER TABLE metric_sketches DROP CONSTRAINT metric_sketches_pkey`,
+					`ALTER TABLE metric_sketches ADD PRIMARY KEY (resource, resource_id, name, bucket)`,
+					`ALTER TABLE metric_sketches DROP COLUMN tenant`,
+					`ALTER TABLE metrics_archive DROP COLUMN tenant`,
+					`ALTER TABLE metrics DROP CONSTRAINT unique_resource_metric`,
+					`ALTER TABLE metrics ADD CONSTRAINT unique_resource_metric UNIQUE (resource, resource_id, name)`,
+					`ALTER TABLE metrics DROP COLUMN tenant`,
+				},
+				"mysql": {
+					`ALTER TABLE metric_milestones
+						DROP PRIMARY KEY,
+						ADD PRIMARY KEY (resource, resource_id, name, milestone),
+						DROP COLUMN tenant,
+						MODIFY resource VARCHAR(255) NOT NULL`,
+					`ALTER TABLE metric_sketches
+						DROP PRIMARY KEY,
+						ADD PRIMARY KEY (resource, resource_id, name, bucket),
+						DROP COLUMN tenant,
+						MODIFY resource VARCHAR(255) NOT NULL`,
+					`ALTER TABLE metrics_archive DROP COLUMN tenant`,
+					`ALTER TABLE metrics
+						DROP INDEX unique_resource_metric,
+						ADD UNIQUE KEY unique_resource_metric (resource, resource_id, ` + "`key`" + `),
+						DROP COLUMN tenant,
+						MODIFY resource VARCHAR(255) NOT NULL`,
+				},
+				"sqlite": {
+					`CREATE TABLE metric_milestones_rebuild (
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						milestone INTEGER NOT NULL,
+						reached_at TEXT NOT NULL DEFAULT (datetime('now')),
+						PRIMARY KEY (resource, resource_id, name, milestone)
+					)`,
+					`INSERT INTO metric_milestones_rebuild (resource, resource_id, name, milestone, reached_at)
+						SELECT resource, resource_id, name, milestone, reached_at FROM metric_milestones`,
+					`DROP TABLE metric_milestones`,
+					`ALTER TABLE metric_milestones_rebuild RENAME TO metric_milestones`,
+					`CREATE TABLE metric_sketches_rebuild (
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						bucket TEXT NOT NULL,
+						sketch BLOB NOT NULL,
+						PRIMARY KEY (resource, resource_id, name, bucket)
+					)`,
+					`INSERT INTO metric_sketches_rebuild (resource, resource_id, name, bucket, sketch)
+						SELECT resource, resource_id, name, bucket, sketch FROM metric_sketches`,
+					`DROP TABLE metric_sketches`,
+					`ALTER TABLE metric_sketches_rebuild RENAME TO metric_sketches`,
+					`ALTER TABLE metrics_archive DROP COLUMN tenant`,
+					`CREATE TABLE metrics_rebuild (
+						id TEXT PRIMARY KEY,
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						value INTEGER NOT NULL DEFAULT 0,
+						created_at TEXT NOT NULL DEFAULT (datetime('now')),
+						UNIQUE (resource, resource_id, name)
+					)`,
+					`INSERT INTO metrics_rebuild (id, resource, resource_id, name, value, created_at)
+						SELECT id, resource, resource_id, name, value, created_at FROM metrics`,
+					`DROP TABLE metrics`,
+					`ALTER TABLE metrics_rebuild RENAME TO metrics`,
+					`CREATE INDEX IF NOT EXISTS idx_metrics_resource ON metrics(resource, resource_id, name)`,
+					`CREATE INDEX IF NOT EXISTS idx_metrics_key ON metrics(name, created_at)`,
+					`CREATE INDEX IF NOT EXISTS idx_metrics_resource_id ON metrics(resource_id)`,
+				},
+			})
+		},
+	)
+
+	// One row per manual update or delete of a metric. The metric's tuple is
+	// copied so the trail outlives the metric; new_value is NULL for deletes.
+	builder.Add(
+		"20260308000000000",
+		"create_metric_audit_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS metric_audit (
+					id UUID PRIMARY KEY,
+					tenant VARCHAR(255) NOT NULL DEFAULT '',
+					metric_id UUID NOT NULL,
+					resource TEXT NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					operation VARCHAR(16) NOT NULL,
+					actor VARCHAR(255) NOT NULL DEFAULT '',
+					old_value INTEGER NOT NULL,
+					new_value INTEGER,
+					created_at TIMESTAMP(3) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS metric_audit (
+					id CHAR(36) PRIMARY KEY,
+					tenant VARCHAR(255) CHARACTER SET ascii NOT NULL DEFAULT '',
+					metric_id CHAR(36) NOT NULL,
+					resource VARCHAR(255) NOT NULL,
+					resource_id VARCHAR(255) NOT NULL,
+					name VARCHAR(255) NOT NULL,
+					operation VARCHAR(16) NOT NULL,
+					actor VARCHAR(255) NOT NULL DEFAULT '',
+					old_value INT NOT NULL,
+					new_value INT NULL,
+					created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+					INDEX idx_metric_audit_metric (metric_id, created_at)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS metric_audit (
+					id TEXT PRIMARY KEY,
+					tenant TEXT NOT NULL DEFAULT '',
+					metric_id TEXT NOT NULL,
+					resource TEXT NOT NULL,
+					resource_id TEXT NOT NULL,
+					name TEXT NOT NULL,
+					operation TEXT NOT NULL,
+					actor TEXT NOT NULL DEFAULT '',
+					old_value INTEGER NOT NULL,
+					new_value INTEGER,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				return migrations.CreateIndex(ctx, db, "idx_metric_audit_metric", "metric_audit", "metric_id, created_at")
+			}
+
+			return nil
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() == "postgres" || db.DriverName() == "sqlite" {
+				_ = migrations.DropIndex(ctx, db, "idx_metric_audit_metric", "metric_audit")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "metric_audit")
+		},
+	)
+
+	// Deleting a metric through the API stamps deleted_at instead of removing
+	// the row, so it can be restored.
+	builder.Add(
+		"20260309000000000",
+		"add_deleted_at_to_metrics",
+		func(ctx context.Context, db database.Database) error {
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE metrics ADD COLUMN deleted_at TIMESTAMP(0) WITH TIME ZONE NULL`,
+				MySQL:    `ALTER TABLE metrics ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL`,
+				SQLite:   `ALTER TABLE metrics ADD COLUMN deleted_at TIMESTAMP`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE metrics DROP COLUMN deleted_at`,
+				MySQL:    `ALTER TABLE metrics DROP COLUMN deleted_at`,
+				SQLite:   `ALTER TABLE metrics DROP COLUMN deleted_at`,
+			})
+		},
+	)
+
+	// version is bumped by every write and served as the ETag of a metric, so
+	// PUT /metrics/:id can be made conditional with If-Match.
+	builder.Add(
+		"20260310000000000",
+		"add_version_to_metrics",
+		func(ctx context.Context, db database.Database) error {
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE metrics ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+				MySQL:    `ALTER TABLE metrics ADD COLUMN version INT NOT NULL DEFAULT 1`,
+				SQLite:   `ALTER TABLE metrics ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE metrics DROP COLUMN version`,
+				MySQL:    `ALTER TABLE metrics DROP COLUMN version`,
+				SQLite:   `ALTER TABLE metrics DROP COLUMN version`,
+			})
+		},
+	)
+
+	// updated_at records the last write of a metric, which created_at held on
+	// MySQL through ON UPDATE CURRENT_TIMESTAMP. Existing rows start from
+	// created_at. MySQL's created_at stops following writes before the copy,
+	// which would otherwise bump it. SQLite cannot add a column defaulting to
+	// CURRENT_TIMESTAMP, so its updated_at is nullable; the writer always sets
+	// it.
+	builder.Add(
+		"20260311000000000",
+		"add_updated_at_to_metrics",
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`ALTER TABLE metrics ADD COLUMN updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP`,
+					`UPDATE metrics SET updated_at = created_at`,
+				},
+				"mysql": {
+					`ALTER TABLE metrics
+						MODIFY created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+						ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`,
+					`UPDATE metrics SET updated_at = created_at`,
+				},
+				"sqlite": {
+					`ALTER TABLE metrics ADD COLUMN updated_at TIMESTAMP`,
+					`UPDATE metrics SET updated_at = created_at`,
+				},
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`ALTER TABLE metrics DROP COLUMN updated_at`,
+				},
+				"mysql": {
+					`UPDATE metrics SET created_at = updated_at`,
+					`ALTER TABLE metrics
+						MODIFY created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+						DROP COLUMN updated_at`,
+				},
+				"sqlite": {
+					`ALTER TABLE metrics DROP COLUMN updated_at`,
+				},
+			})
+		},
+	)
+
+	// The metric name column was created as `key` on MySQL while every query
+	// uses name, as Postgres and SQLite do. Rename it and rebuild the metric
+	// indexes under the same names on every dialect, idx_metrics_key becoming
+	// idx_metrics_name. (The SQLite indexes of the first migration referenced
+	// key too and could never be created; that migration was fixed in place.)
+	builder.Add(
+		"20260312000000000",
+		"converge_metrics_name_column",
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`DROP INDEX IF EXISTS idx_metrics_key`,
+					`DROP INDEX IF EXISTS idx_metrics_resource`,
+					`CREATE INDEX idx_metrics_resource ON metrics(resource, resource_id, name)`,
+					`CREATE INDEX idx_metrics_name ON metrics(name, created_at)`,
+				},
+				"mysql": {
+					`ALTER TABLE metrics RENAME COLUMN ` + "`key`" + ` TO name`,
+					`ALTER TABLE metrics
+						DROP INDEX idx_metrics_key,
+						DROP INDEX idx_metrics_resource,
+						ADD INDEX idx_metrics_resource (resource, resource_id, name),
+						ADD INDEX idx_metrics_name (name, created_at)`,
+				},
+				"sqlite": {
+					`DROP INDEX IF EXISTS idx_metrics_key`,
+					`DROP INDEX IF EXISTS idx_metrics_resource`,
+					`CREATE INDEX idx_metrics_resource ON metrics(resource, resource_id, name)`,
+					`CREATE INDEX idx_metrics_name ON metrics(name, created_at)`,
+				},
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`DROP INDEX IF EXISTS idx_metrics_name`,
+					`CREATE INDEX idx_metrics_key ON metrics(name, created_at)`,
+				},
+				"mysql": {
+					`ALTER TABLE metrics
+						DROP INDEX idx_metrics_name,
+						ADD INDEX idx_metrics_key (name, created_at)`,
+					`ALTER TABLE metrics RENAME COLUMN name TO ` + "`key`",
+				},
+				"sqlite": {
+					`DROP INDEX IF EXISTS idx_metrics_name`,
+					`CREATE INDEX idx_metrics_key ON metrics(name, created_at)`,
+				},
+			})
+		},
+	)
+
+	// SQLite declared metrics.created_at as TEXT, which its driver hands back
+	// as a string that cannot be scanned into a time, and could only add
+	// updated_at as nullable. Rebuild the table with TIMESTAMP columns matching
+	// the other dialects. Postgres and MySQL are unchanged.
+	builder.Add(
+		"20260313000000000",
+		"sqlite_metrics_timestamps",
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {},
+				"mysql":    {},
+				"sqlite": {
+					`CREATE TABLE metrics_rebuild (
+						id TEXT PRIMARY KEY,
+						tenant TEXT NOT NULL DEFAULT '',
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						value INTEGER NOT NULL DEFAULT 0,
+						created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+						deleted_at TIMESTAMP,
+						version INTEGER NOT NULL DEFAULT 1,
+						updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+						UNIQUE (tenant, resource, resource_id, name)
+					)`,
+					`INSERT INTO metrics_rebuild (id, tenant, resource, resource_id, name, value, created_at, deleted_at, version, updated_at)
+						SELECT id, tenant, resource, resource_id, name, value, created_at, deleted_at, version, COALESCE(updated_at, created_at)
+						FROM metrics`,
+					`DROP TABLE metrics`,
+					`ALTER TABLE metrics_rebuild RENAME TO metrics`,
+					`CREATE INDEX idx_metrics_resource ON metrics(resource, resource_id, name)`,
+					`CREATE INDEX idx_metrics_name ON metrics(name, created_at)`,
+					`CREATE INDEX idx_metrics_resource_id ON metrics(resource_id)`,
+				},
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {},
+				"mysql":    {},
+				"sqlite": {
+					`CREATE TABLE metrics_rebuild (
+						id TEXT PRIMARY KEY,
+						tenant TEXT NOT NULL DEFAULT '',
+						resource TEXT NOT NULL,
+						resource_id TEXT NOT NULL,
+						name TEXT NOT NULL,
+						value INTEGER NOT NULL DEFAULT 0,
+						created_at TEXT NOT NULL DEFAULT (datetime('now')),
+						deleted_at TIMESTAMP,
+						version INTEGER NOT NULL DEFAULT 1,
+						updated_at TIMESTAMP,
+						UNIQUE (tenant, resource, resource_id, name)
+					)`,
+					`INSERT INTO metrics_rebuild (id, tenant, resource, resource_id, name, value, created_at, deleted_at, version, updated_at)
+						SELECT id, tenant, resource, resource_id, name, value, created_at, deleted_at, version, updated_at
+						FROM metrics`,
+					`DROP TABLE metrics`,
+					`ALTER TABLE metrics_rebuild RENAME TO metrics`,
+					`CREATE INDEX idx_metrics_resource ON metrics(resource, resource_id, name)`,
+					`CREATE INDEX idx_metrics_name ON metrics(name, created_at)`,
+					`CREATE INDEX idx_metrics_resource_id ON metrics(resource_id)`,
+				},
+			})
+		},
+	)
+
+	// Alert rules gain a tenant, like the metrics they watch; rules created
+	// before it belong to the default tenant ''. The tenant leads the target
+	// index since every lookup is scoped to one.
+	builder.Add(
+		"20260314000000000",
+		"add_tenant_to_metric_alerts",
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`ALTER TABLE metric_alerts ADD COLUMN tenant VARCHAR(255) NOT NULL DEFAULT ''`,
+					`DROP INDEX IF EXISTS idx_metric_alerts_target`,
+					`CREATE INDEX idx_metric_alerts_target ON metric_alerts(tenant, resource, name)`,
+				},
+				"mysql": {
+					`ALTER TABLE metric_alerts
+						ADD COLUMN tenant VARCHAR(255) CHARACTER SET ascii NOT NULL DEFAULT '',
+						DROP INDEX idx_metric_alerts_target,
+						ADD INDEX idx_metric_alerts_target (tenant, resource, name)`,
+				},
+				"sqlite": {
+					`ALTER TABLE metric_alerts ADD COLUMN tenant TEXT NOT NULL DEFAULT ''`,
+					`DROP INDEX IF EXISTS idx_metric_alerts_target`,
+					`CREATE INDEX idx_metric_alerts_target ON metric_alerts(tenant, resource, name)`,
+				},
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			return execEach(ctx, db, map[string][]string{
+				"postgres": {
+					`DROP INDEX IF EXISTS idx_metric_alerts_target`,
+					`CREATE INDEX idx_metric_alerts_target ON metric_alerts(resource, name)`,
+					`ALTER TABLE metric_alerts DROP COLUMN tenant`,
+				},
+				"mysql": {
+					`ALTER TABLE metric_alerts
+						DROP INDEX idx_metric_alerts_target,
+						ADD INDEX idx_metric_alerts_target (resource, name),
+						DROP COLUMN tenant`,
+				},
+				"sqlite": {
+					`DROP INDEX IF EXISTS idx_metric_alerts_target`,
+					`CREATE INDEX idx_metric_alerts_target ON metric_alerts(resource, name)`,
+					`ALTER TABLE metric_alerts DROP COLUMN tenant`,
+				},
+			})
+		},
+	)
+
 	return builder.Build()
 }
+
+// execEach runs the statements listed for db's driver in order. Migrations run
+// in a transaction, so a failing statement rolls back the ones before it.
+func execEach(ctx context.Context, db database.Database, statements map[string][]string) error {
+	stmts, ok := statements[db.DriverName()]
+	if !ok {
+		return fmt.Errorf("unsupported database driver: %s", db.DriverName())
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package migrations
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/nicolasbonnici/gorest/database"
+	_ "github.com/nicolasbonnici/gorest/database/sqlite"
+	"github.com/nicolasbonnici/gorest/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T) database.Database {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "migrations_test.db")
+	db, err := database.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// tables lists the tables of db, leaving out SQLite's own and the migration
+// tracking table.
+func tables(t *testing.T, db database.Database) []string {
+	t.Helper()
+
+	rows, err := db.Query(context.Background(),
+		`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations' ORDER BY name`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	return names
+}
+
+// columns maps the columns of table to their declared types.
+func columns(t *testing.T, db database.Database, table string) map[string]string {
+	t.Helper()
+
+	rows, err := db.Query(context.Background(), `SELECT name, type FROM pragma_table_info(?)`, table)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		require.NoError(t, rows.Scan(&name, &typ))
+		out[name] = typ
+	}
+	require.NoError(t, rows.Err())
+	return out
+}
+
+// indexes maps the explicitly created indexes of table to their columns.
+func indexes(t *testing.T, db database.Database, table string) map[string][]string {
+	t.Helper()
+
+	rows, err := db.Query(context.Background(),
+		`SELECT il.name, ii.name FROM pragma_index_list(?) AS il, pragma_index_info(il.name) AS ii
+		WHERE il.origin = 'c' ORDER BY il.name, ii.seqno`, table)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	out := make(map[string][]string)
+	for rows.Next() {
+		var index, column string
+		require.NoError(t, rows.Scan(&index, &column))
+		out[index] = append(out[index], column)
+	}
+	require.NoError(t, rows.Err())
+	return out
+}
+
+func TestGetMigrations_UpDownCycle(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	source := GetMigrations()
+	all, err := source.Migrations()
+	require.NoError(t, err)
+	migrator := migrations.NewMigrator(db, source)
+
+	for cycle := 1; cycle <= 2; cycle++ {
+		require.NoError(t, migrator.Up(ctx), "cycle %d", cycle)
+
+		assert.Equal(t, []string{
+			"metric_alerts", "metric_audit", "metric_dedup", "metric_milestones",
+			"metric_sketches", "metrics", "metrics_archive",
+		}, tables(t, db))
+
+		metrics := columns(t, db, "metrics")
+		assert.ElementsMatch(t, []string{
+			"id", "tenant", "resource", "resource_id", "name", "value",
+			"created_at", "deleted_at", "version", "updated_at",
+		}, keys(metrics))
+		assert.Equal(t, "INTEGER", metrics["version"])
+		assert.Equal(t, "TIMESTAMP", metrics["created_at"])
+		assert.Equal(t, "TIMESTAMP", metrics["updated_at"])
+		assert.Equal(t, "TIMESTAMP", metrics["deleted_at"])
+
+		assert.Equal(t, map[string][]string{
+			"idx_metrics_name":        {"name", "created_at"},
+			"idx_metrics_resource":    {"resource", "resource_id", "name"},
+			"idx_metrics_resource_id": {"resource_id"},
+		}, indexes(t, db, "metrics"))
+
+		assert.Contains(t, columns(t, db, "metric_sketches"), "tenant")
+		assert.Contains(t, columns(t, db, "metric_milestones"), "tenant")
+		assert.Contains(t, columns(t, db, "metrics_archive"), "tenant")
+		assert.Contains(t, columns(t, db, "metric_alerts"), "tenant")
+		assert.Equal(t, map[string][]string{
+			"idx_metric_alerts_target": {"tenant", "resource", "name"},
+		}, indexes(t, db, "metric_alerts"))
+
+		for range all {
+			require.NoError(t, migrator.Down(ctx), "cycle %d", cycle)
+		}
+		assert.Empty(t, tables(t, db), "cycle %d", cycle)
+	}
+}
+
+func TestGetMigrations_KeepRows(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	migrator := migrations.NewMigrator(db, GetMigrations())
+
+	require.NoError(t, migrator.UpTo(ctx, "20260307000000000"))
+	_, err := db.Exec(ctx,
+		`INSERT INTO metrics (id, tenant, resource, resource_id, name, value, created_at)
+		VALUES ('m-1', 'acme', 'post', 'p-1', 'views', 42, '2026-01-02 03:04:05')`)
+	require.NoError(t, err)
+
+	require.NoError(t, migrator.Up(ctx))
+
+	var (
+		name           string
+		value, version int
+		sameTimestamps bool
+	)
+	require.NoError(t, db.QueryRow(ctx,
+		`SELECT name, value, version, updated_at = created_at FROM metrics WHERE id = 'm-1'`).
+		Scan(&name, &value, &version, &sameTimestamps))
+	assert.Equal(t, "views", name)
+	assert.Equal(t, 42, value)
+	assert.Equal(t, 1, version)
+	assert.True(t, sameTimestamps, "updated_at starts from created_at")
+
+	require.NoError(t, migrator.DownTo(ctx, "20260307000000000"))
+	require.NoError(t, db.QueryRow(ctx, `SELECT name, value FROM metrics WHERE id = 'm-1'`).Scan(&name, &value))
+	assert.Equal(t, "views", name)
+	assert.Equal(t, 42, value)
+	assert.NotContains(t, columns(t, db, "metrics"), "updated_at")
+}
+
+func keys(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/logger"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+const (
+	milestonesTableName       = "metric_milestones"
+	defaultMilestoneQueueSize = 256
+	defaultMilestoneCacheSize = 100000
+)
+
+// MilestoneEvent is emitted once per resource the first time a metric reaches
+// one of the thresholds configured for its key in Config.Milestones.
+type MilestoneEvent struct {
+	Tenant     string    `json:"tenant,omitempty"`
+	Resource   string    `json:"resource"`
+	ResourceId string    `json:"resourceId"`
+	Key        string    `json:"key"`
+	Milestone  int       `json:"milestone"`
+	Value      int       `json:"value"`
+	ReachedAt  time.Time `json:"reachedAt"`
+}
+
+// milestoneListeners holds the in-process milestone subscribers. Its zero value
+// is ready to use so subscribers can register before SetupEndpoints.
+type milestoneListeners struct {
+	mu    sync.RWMutex
+	fns   []func(MilestoneEvent)
+	chans []chan MilestoneEvent
+}
+
+// OnMilestone registers fn to be called for every milestone event. Callbacks
+// run sequentially on a dedicated goroutine, never on the request path or the
+// batch writer; while its queue is full, new milestones are left unrecorded
+// and emitted on a later flush that reaches them.
+func (p *MetricsPlugin) OnMilestone(fn func(MilestoneEvent)) {
+	p.milestoneListeners.mu.Lock()
+	defer p.milestoneListeners.mu.Unlock()
+	p.milestoneListeners.fns = append(p.milestoneListeners.fns, fn)
+}
+
+// MilestoneEvents returns a channel receiving milestone events. Delivery is
+// at most once: events that overflow its buffer are dropped and logged, not
+// redelivered. The channel is closed by Close.
+func (p *MetricsPlugin) MilestoneEvents(buffer int) <-chan MilestoneEvent {
+	ch := make(chan MilestoneEvent, buffer)
+	p.milestoneListeners.mu.Lock()
+	defer p.milestoneListeners.mu.Unlock()
+	p.milestoneListeners.chans = append(p.milestoneListeners.chans, ch)
+	return ch
+}
+
+func (l *milestoneListeners) emit(event MilestoneEvent) {
+	l.mu.RLock()
+	fns, chans := l.fns, l.chans
+	l.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(event)
+	}
+	for _, ch := range chans {
+		select {
+		case ch <- event:
+		default:
+			logger.Log.Warn("metrics: milestone subscriber too slow, dropping event",
+				"resource", event.Resource,
+				"key", event.Key,
+				"milestone", event.Milestone,
+			)
+		}
+	}
+}
+
+func (l *milestoneListeners) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ch := range l.chans {
+		close(ch)
+	}
+	l.chans = nil
+}
+
+// milestoneTracker is a batchWriter flush listener that records the
+// milestones reached by persisted metrics and hands the new ones to the
+// registered listeners.
+type milestoneTracker struct {
+	db         database.Database
+	milestones map[string][]int
+	listeners  *milestoneListeners
+	timeout    time.Duration
+
+	// claimed remembers, per metric, the highest milestone known to be
+	// recorded, so a flush only claims the milestones crossed since. It is
+	// only used from check, which runs on the batch writer goroutine.
+	claimed *lruCache[milestoneTuple, int]
+
+	queue chan MilestoneEvent
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newMilestoneTracker(db database.Database, milestones map[string][]int, listeners *milestoneListeners) *milestoneTracker {
+	sorted := make(map[string][]int, len(milestones))
+	for key, thresholds := range milestones {
+		s := append([]int(nil), thresholds...)
+		sort.Ints(s)
+		sorted[key] = s
+	}
+
+	t := &milestoneTracker{
+		db:         db,
+		milestones: sorted,
+		listeners:  listeners,
+		timeout:    defaultWriteTimeout,
+		claimed:    newLRUCache[milestoneTuple, int](defaultMilestoneCacheSize),
+		queue:      make(chan MilestoneEvent, defaultMilestoneQueueSize),
+	}
+
+	t.wg.Add(1)
+	go t.run()
+
+	return t
+}
+
+func (t *milestoneTracker) check(batch []Metric) {
+	if len(t.milestones) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	for _, m := range batch {
+		thresholds := t.milestones[m.Key]
+		if len(thresholds) == 0 {
+			continue
+		}
+
+		tuple := milestoneTuple{m.Tenant, m.Resource, m.ResourceId, m.Key}
+		recorded, known := t.claimed.get(tuple)
+		highest, advanced := recorded, false
+		for _, milestone := range thresholds {
+			if m.Value < milestone {
+				break
+			}
+			if known && milestone <= recorded {
+				continue
+			}
+
+			claimed, err := t.claim(ctx, m, milestone)
+			if err != nil {
+				logger.Log.Error("metrics: failed to record milestone",
+					"error", err,
+					"resource", m.Resource,
+					"key", m.Key,
+					"milestone", milestone,
+				)
+				// The milestones above are retried on the next flush.
+				break
+			}
+			if claimed && !t.enqueue(MilestoneEvent{
+				Tenant:     m.Tenant,
+				Resource:   m.Resource,
+				ResourceId: m.ResourceId,
+				Key:        m.Key,
+				Milestone:  milestone,
+				Value:      m.Value,
+				ReachedAt:  time.Now().UTC(),
+			}) {
+				// Hand the milestone back so a later flush emits it
+				// instead of it being recorded but never delivered.
+				t.release(ctx, m, milestone)
+				break
+			}
+			highest, advanced = milestone, true
+		}
+		if advanced {
+			t.claimed.set(tuple, highest)
+		}
+	}
+}
+
+// claim records that m reached milestone and reports whether this call was
+// the first to do so.
+func (t *milestoneTracker) claim(ctx context.Context, m Metric, milestone int) (bool, error) {
+	sqlStr, args, err := query.New(t.db.Dialect()).
+		Insert(milestonesTableName).
+		Columns("tenant", "resource", "resource_id", "name", "milestone").
+		Values(m.Tenant, m.Resource, m.ResourceId, m.Key, milestone).
+		Build()
+	if err != nil {
+		return false, err
+	}
+
+	_, insertErr := t.db.Exec(ctx, sqlStr, args...)
+	if insertErr == nil {
+		return true, nil
+	}
+
+	// The insert fails on the primary key once the milestone is recorded;
+	// anything else is a real error.
+	sqlStr, args, err = query.New(t.db.Dialect()).
+		Select("milestone").
+		From(milestonesTableName).
+		Where(metricCondition(m)).
+		Where(query.Eq("milestone", milestone)).
+		Build()
+	if err != nil {
+		return false, err
+	}
+
+	var existing int
+	if err := t.db.QueryRow(ctx, sqlStr, args...).Scan(&existing); err != nil {
+		return false, insertErr
+	}
+	return false, nil
+}
+
+// release removes a claim whose event could not be queued.
+func (t *milestoneTracker) release(ctx context.Context, m Metric, milestone int) {
+	sqlStr, args, err := query.New(t.db.Dialect()).
+		Delete(milestonesTableName).
+		Where(metricCondition(m)).
+		Where(query.Eq("milestone", milestone)).
+		Build()
+	if err == nil {
+		_, err = t.db.Exec(ctx, sqlStr, args...)
+	}
+	if err != nil {
+		logger.Log.Error("metrics: failed to release milestone",
+			"error", err,
+			"resource", m.Resource,
+			"key", m.Key,
+			"milestone", milestone,
+		)
+	}
+}
+
+type milestoneTuple struct{ tenant, resource, resourceID, key string }
+
+// enqueue hands event to the delivery goroutine and reports whether it was
+// queued.
+func (t *milestoneTracker) enqueue(event MilestoneEvent) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return false
+	}
+	select {
+	case t.queue <- event:
+		return true
+	default:
+		logger.Log.Warn("metrics: milestone queue full, retrying on a later flush",
+			"resource", event.Resource,
+			"key", event.Key,
+			"milestone", event.Milestone,
+		)
+		return false
+	}
+}
+
+func (t *milestoneTracker) run() {
+	defer t.wg.Done()
+
+	for event := range t.queue {
+		t.listeners.emit(event)
+	}
+}
+
+// shutdown delivers the queued events, then closes the channel subscribers.
+func (t *milestoneTracker) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		t.listeners.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMilestoneTracker_EmitsEachMilestoneOnce(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{}
+
+	var mu sync.Mutex
+	var called []MilestoneEvent
+	p.OnMilestone(func(e MilestoneEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		called = append(called, e)
+	})
+	events := p.MilestoneEvents(16)
+
+	tracker := newMilestoneTracker(db, map[string][]int{"views": {1000, 100, 10}}, &p.milestoneListeners)
+
+	views := sampleMetric()
+	views.Key, views.Value = "views", 150
+	likes := sampleMetric()
+	likes.Key, likes.Value = "likes", 5000
+
+	tracker.check([]Metric{views, likes})
+	// Re-flushing the same tuple, even with a higher value, must not announce
+	// the milestones it already reached.
+	views.Value = 200
+	tracker.check([]Metric{views})
+	views.Value = 1000
+	tracker.check([]Metric{views})
+
+	require.NoError(t, tracker.shutdown(context.Background()))
+
+	var received []int
+	for e := range events {
+		assert.Equal(t, views.ResourceId, e.ResourceId)
+		assert.Equal(t, "views", e.Key)
+		received = append(received, e.Milestone)
+	}
+	assert.Equal(t, []int{10, 100, 1000}, received)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, called, 3)
+	assert.Equal(t, 150, called[0].Value)
+	assert.Equal(t, 1000, called[2].Value)
+	assert.Equal(t, 3, countRows(t, db, "metric_milestones"))
+}
+
+func TestMilestoneTracker_SeparatesResources(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{}
+	events := p.MilestoneEvents(16)
+	tracker := newMilestoneTracker(db, map[string][]int{"views": {10}}, &p.milestoneListeners)
+
+	first := sampleMetric()
+	first.Key, first.Value = "views", 10
+	second := sampleMetric()
+	second.Key, second.Value = "views", 12
+
+	tracker.check([]Metric{first, second})
+	require.NoError(t, tracker.shutdown(context.Background()))
+
+	var ids []string
+	for e := range events {
+		ids = append(ids, e.ResourceId)
+	}
+	assert.ElementsMatch(t, []string{first.ResourceId, second.ResourceId}, ids)
+}
+
+func TestMilestoneTracker_WithoutMilestonesSkipsDatabase(t *testing.T) {
+	// Hosts that configure no milestones need no metric_milestones table.
+	db := newTestDB(t)
+	p := &MetricsPlugin{}
+	events := p.MilestoneEvents(1)
+	tracker := newMilestoneTracker(db, nil, &p.milestoneListeners)
+
+	m := sampleMetric()
+	m.Value = 1000000
+	tracker.check([]Metric{m})
+	require.NoError(t, tracker.shutdown(context.Background()))
+
+	_, open := <-events
+	assert.False(t, open)
+}
+
+func TestMilestoneTracker_DropsEventsForSlowChannels(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{}
+
+	var mu sync.Mutex
+	var called []int
+	p.OnMilestone(func(e MilestoneEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		called = append(called, e.Milestone)
+	})
+	// Nobody drains this channel; it must not hold up the other subscribers
+	// or the tracker.
+	events := p.MilestoneEvents(0)
+
+	tracker := newMilestoneTracker(db, map[string][]int{"views": {1, 2, 3}}, &p.milestoneListeners)
+
+	m := sampleMetric()
+	m.Key, m.Value = "views", 3
+	tracker.check([]Metric{m})
+	require.NoError(t, tracker.shutdown(context.Background()))
+
+	_, open := <-events
+	assert.False(t, open)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{1, 2, 3}, called)
+}
+
+func TestMilestoneTracker_ClaimsOnlyCrossedMilestones(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{}
+	events := p.MilestoneEvents(16)
+	tracker := newMilestoneTracker(db, map[string][]int{"views": {10, 100, 1000}}, &p.milestoneListeners)
+
+	views := sampleMetric()
+	views.Key, views.Value = "views", 150
+	tracker.check([]Metric{views})
+	require.Equal(t, 2, countRows(t, db, "metric_milestones"))
+
+	// With the claimed milestones remembered, later flushes leave the table
+	// alone until the next milestone is crossed.
+	_, err := db.Exec(context.Background(), "DELETE FROM metric_milestones")
+	require.NoError(t, err)
+	views.Value = 200
+	tracker.check([]Metric{views})
+	assert.Equal(t, 0, countRows(t, db, "metric_milestones"))
+
+	views.Value = 1000
+	tracker.check([]Metric{views})
+	assert.Equal(t, 1, countRows(t, db, "metric_milestones"))
+
+	require.NoError(t, tracker.shutdown(context.Background()))
+	var received []int
+	for e := range events {
+		received = append(received, e.Milestone)
+	}
+	assert.Equal(t, []int{10, 100, 1000}, received)
+}
+
+func TestMilestoneTracker_RetriesMilestonesItCouldNotQueue(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{}
+	events := p.MilestoneEvents(16)
+
+	// A one-slot queue with no delivery goroutine yet, so only the first
+	// milestone fits.
+	tracker := &milestoneTracker{
+		db:         db,
+		milestones: map[string][]int{"views": {10, 100}},
+		listeners:  &p.milestoneListeners,
+		timeout:    defaultWriteTimeout,
+		claimed:    newLRUCache[milestoneTuple, int](defaultMilestoneCacheSize),
+		queue:      make(chan MilestoneEvent, 1),
+	}
+
+	views := sampleMetric()
+	views.Key, views.Value = "views", 150
+	tracker.check([]Metric{views})
+	assert.Equal(t, 1, countRows(t, db, "metric_milestones"))
+
+	tracker.wg.Add(1)
+	go tracker.run()
+	require.Eventually(t, func() bool { return len(tracker.queue) == 0 }, time.Second, time.Millisecond)
+
+	views.Value = 160
+	tracker.check([]Metric{views})
+	assert.Equal(t, 2, countRows(t, db, "metric_milestones"))
+
+	require.NoError(t, tracker.shutdown(context.Background()))
+	var received []int
+	for e := range events {
+		received = append(received, e.Milestone)
+	}
+	assert.Equal(t, []int{10, 100}, received)
+}
@@ -6,13 +6,61 @@ import (
 
 type Metric struct {
 	Id         string     `json:"id,omitempty" db:"id"`
+	Tenant     string     `json:"tenant,omitempty" db:"tenant"`
 	Resource   string     `json:"resource" db:"resource"`
 	ResourceId string     `json:"resourceId" db:"resource_id"`
 	Key        string     `json:"key" db:"name"`
 	Value      int        `json:"value" db:"value"`
+	Version    int        `json:"version,omitempty" db:"version"`
 	CreatedAt  *time.Time `json:"createdAt,omitempty" db:"created_at"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty" db:"updated_at"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
 }
 
 func (Metric) TableName() string {
 	return "metrics"
 }
+
+// AlertRule fires a signed webhook when a persisted metric of its Tenant and
+// Resource/Key (and ResourceId, unless empty) satisfies Operator against
+// Threshold.
+type AlertRule struct {
+	Id              string     `json:"id,omitempty" db:"id"`
+	Tenant          string     `json:"tenant,omitempty" db:"tenant"`
+	Resource        string     `json:"resource" db:"resource"`
+	ResourceId      string     `json:"resourceId" db:"resource_id"`
+	Key             string     `json:"key" db:"name"`
+	Operator        string     `json:"operator" db:"operator"`
+	Threshold       int        `json:"threshold" db:"threshold"`
+	WebhookURL      string     `json:"webhookUrl" db:"webhook_url"`
+	Secret          string     `json:"-" db:"secret"`
+	CooldownSeconds int        `json:"cooldownSeconds" db:"cooldown_seconds"`
+	Enabled         bool       `json:"enabled" db:"enabled"`
+	CreatedAt       *time.Time `json:"createdAt,omitempty" db:"created_at"`
+}
+
+func (AlertRule) TableName() string {
+	return "metric_alerts"
+}
+
+// AuditEntry records one manual update or delete of a metric: who made it
+// (the authenticated user ID, empty when anonymous), the Operation
+// (OperationUpdate, OperationDelete or OperationRestore) and the value before
+// and after (NewValue is nil for deletes).
+type AuditEntry struct {
+	Id         string     `json:"id,omitempty" db:"id"`
+	Tenant     string     `json:"tenant,omitempty" db:"tenant"`
+	MetricId   string     `json:"metricId" db:"metric_id"`
+	Resource   string     `json:"resource" db:"resource"`
+	ResourceId string     `json:"resourceId" db:"resource_id"`
+	Key        string     `json:"key" db:"name"`
+	Operation  string     `json:"operation" db:"operation"`
+	Actor      string     `json:"actor" db:"actor"`
+	OldValue   int        `json:"oldValue" db:"old_value"`
+	NewValue   *int       `json:"newValue" db:"new_value"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty" db:"created_at"`
+}
+
+func (AuditEntry) TableName() string {
+	return "metric_audit"
+}
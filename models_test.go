@@ -187,6 +187,147 @@ func TestCreateMetricDTO_Validate_OnlyPositiveValues(t *testing.T) {
 	}
 }
 
+func TestCreateMetricDTO_Validate_ResourceIDFormats(t *testing.T) {
+	config := &Config{
+		AllowedTypes: []string{"post", "user", "tag"},
+		ResourceIDFormats: map[string]IDFormat{
+			"user": {Type: IDFormatInt},
+			"tag":  {Type: IDFormatString, Pattern: "[a-z0-9-]+"},
+		},
+		MaxKeyLength: 255,
+	}
+
+	hooks := NewMetricHooks(config)
+
+	tests := []struct {
+		name       string
+		resource   string
+		resourceId string
+		wantErr    bool
+		errMsg     string
+	}{
+		{
+			name:       "uuid remains the default",
+			resource:   "post",
+			resourceId: uuid.New().String(),
+			wantErr:    false,
+		},
+		{
+			name:       "integer id on uuid type rejected",
+			resource:   "post",
+			resourceId: "42",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid UUID",
+		},
+		{
+			name:       "uppercase uuid rejected",
+			resource:   "post",
+			resourceId: "6BA7B810-9DAD-11D1-80B4-00C04FD430C8",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid UUID",
+		},
+		{
+			name:       "braced uuid rejected",
+			resource:   "post",
+			resourceId: "{6ba7b810-9dad-11d1-80b4-00c04fd430c8}",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid UUID",
+		},
+		{
+			name:       "urn uuid rejected",
+			resource:   "post",
+			resourceId: "urn:uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid UUID",
+		},
+		{
+			name:       "unhyphenated uuid rejected",
+			resource:   "post",
+			resourceId: "6ba7b8109dad11d180b400c04fd430c8",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid UUID",
+		},
+		{
+			name:       "integer id",
+			resource:   "user",
+			resourceId: "42",
+			wantErr:    false,
+		},
+		{
+			name:       "non numeric id on int type rejected",
+			resource:   "user",
+			resourceId: "forty-two",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid integer",
+		},
+		{
+			name:       "signed integer id rejected",
+			resource:   "user",
+			resourceId: "+7",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid integer",
+		},
+		{
+			name:       "zero padded integer id rejected",
+			resource:   "user",
+			resourceId: "007",
+			wantErr:    true,
+			errMsg:     "resourceId must be a valid integer",
+		},
+		{
+			name:       "negative integer id",
+			resource:   "user",
+			resourceId: "-7",
+			wantErr:    false,
+		},
+		{
+			name:       "slug matching pattern",
+			resource:   "tag",
+			resourceId: "golang-tips",
+			wantErr:    false,
+		},
+		{
+			name:       "slug partially matching pattern rejected",
+			resource:   "tag",
+			resourceId: "Golang tips",
+			wantErr:    true,
+			errMsg:     "resourceId does not match the expected format",
+		},
+		{
+			name:       "empty string id rejected",
+			resource:   "tag",
+			resourceId: "",
+			wantErr:    true,
+			errMsg:     "resourceId must be between 1 and 255 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dto := MetricCreateDTO{
+				Resource:   tt.resource,
+				ResourceId: tt.resourceId,
+				Key:        "view_count",
+				Value:      1,
+			}
+			err := hooks.CreateHook(nil, dto, &Metric{})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("CreateHook() expected error but got nil")
+					return
+				}
+				if tt.errMsg != "" && !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("CreateHook() error = %v, want %v", err.Error(), tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("CreateHook() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
+
 func TestUpdateMetricDTO_Validate(t *testing.T) {
 	config := &Config{
 		AllowedTypes:       []string{"post"},
@@ -13,6 +13,16 @@ type MetricsPlugin struct {
 	config Config
 	db     database.Database
 	writer *batchWriter
+	hub    *metricHub
+	alerts *alertEvaluator
+	dedup  *dedupFilter
+	quotas *tenantQuotas
+
+	milestones         *milestoneTracker
+	milestoneListeners milestoneListeners
+
+	events eventBus
+	stats  pluginStats
 }
 
 func NewPlugin() plugin.Plugin {
@@ -35,6 +45,25 @@ func (p *MetricsPlugin) Initialize(config map[string]interface{}) error {
 		p.config.Database = db
 	}
 
+	if authorizer, ok := config["authorizer"].(Authorizer); ok {
+		p.config.Authorizer = authorizer
+	}
+
+	if adminRole, ok := config["admin_role"].(string); ok {
+		p.config.AdminRole = adminRole
+	}
+
+	switch extractor := config["tenant_extractor"].(type) {
+	case TenantExtractor:
+		p.config.TenantExtractor = extractor
+	case func(fiber.Ctx) (string, error):
+		p.config.TenantExtractor = extractor
+	}
+
+	if tenantHeader, ok := config["tenant_header"].(string); ok {
+		p.config.TenantHeader = tenantHeader
+	}
+
 	if allowedTypes, ok := config["allowed_types"].([]interface{}); ok {
 		types := make([]string, 0, len(allowedTypes))
 		for _, t := range allowedTypes {
@@ -47,6 +76,10 @@ func (p *MetricsPlugin) Initialize(config map[string]interface{}) error {
 		}
 	}
 
+	if formats, ok := config["resource_id_formats"].(map[string]interface{}); ok {
+		p.config.ResourceIDFormats = parseIDFormats(formats)
+	}
+
 	if maxKeyLength, ok := config["max_key_length"].(int); ok {
 		p.config.MaxKeyLength = maxKeyLength
 	}
@@ -63,12 +96,209 @@ func (p *MetricsPlugin) Initialize(config map[string]interface{}) error {
 		p.config.MaxPaginationLimit = maxPaginationLimit
 	}
 
+	if maxBatchResourceIDs, ok := config["max_batch_resource_ids"].(int); ok {
+		p.config.MaxBatchResourceIDs = maxBatchResourceIDs
+	}
+
+	if streamBufferSize, ok := config["stream_buffer_size"].(int); ok {
+		p.config.StreamBufferSize = streamBufferSize
+	}
+
+	if subscriberBufferSize, ok := config["subscriber_buffer_size"].(int); ok {
+		p.config.SubscriberBufferSize = subscriberBufferSize
+	}
+
+	if dedupWindow, ok := config["dedup_window_seconds"].(int); ok {
+		p.config.DedupWindowSeconds = dedupWindow
+	}
+
+	if dedupCacheSize, ok := config["dedup_cache_size"].(int); ok {
+		p.config.DedupCacheSize = dedupCacheSize
+	}
+
+	if dedupStore, ok := config["dedup_store"].(string); ok {
+		p.config.DedupStore = dedupStore
+	}
+
+	if onResourceDelete, ok := config["on_resource_delete"].(string); ok {
+		p.config.OnResourceDelete = onResourceDelete
+	}
+
+	if milestones, ok := config["milestones"].(map[string]interface{}); ok {
+		p.config.Milestones = parseMilestones(milestones)
+	}
+
+	if uniqueKeys, ok := config["unique_keys"].([]interface{}); ok {
+		keys := make([]string, 0, len(uniqueKeys))
+		for _, k := range uniqueKeys {
+			if str, ok := k.(string); ok {
+				keys = append(keys, str)
+			}
+		}
+		p.config.UniqueKeys = keys
+	}
+
+	if rateLimits, ok := config["rate_limits"].(map[string]interface{}); ok {
+		p.config.RateLimits = parseRateLimits(rateLimits)
+	}
+
+	if quota, ok := config["tenant_quota"].(map[string]interface{}); ok {
+		p.config.TenantQuota = parseTenantQuota(quota)
+	}
+
+	if tenantQuotas, ok := config["tenant_quotas"].(map[string]interface{}); ok {
+		quotas := make(map[string]TenantQuota, len(tenantQuotas))
+		for tenant, v := range tenantQuotas {
+			if quota, ok := v.(map[string]interface{}); ok {
+				quotas[tenant] = parseTenantQuota(quota)
+			}
+		}
+		p.config.TenantQuotas = quotas
+	}
+
+	if keyVisibility, ok := config["key_visibility"].(map[string]interface{}); ok {
+		visibilities := make(map[string]string, len(keyVisibility))
+		for key, v := range keyVisibility {
+			if visibility, ok := v.(string); ok {
+				visibilities[key] = visibility
+			}
+		}
+		p.config.KeyVisibility = visibilities
+	}
+
+	if routeMetrics, ok := config["route_metrics"].(map[string]interface{}); ok {
+		p.config.RouteMetrics = parseRouteMetrics(routeMetrics)
+	}
+
 	return p.config.Validate()
 }
 
+// parseIDFormats accepts both the short form ({"post": "int"}) and the full
+// form ({"post": {"type": "string", "pattern": "[a-z0-9-]+"}}).
+func parseIDFormats(raw map[string]interface{}) map[string]IDFormat {
+	formats := make(map[string]IDFormat, len(raw))
+	for resourceType, v := range raw {
+		switch f := v.(type) {
+		case string:
+			formats[resourceType] = IDFormat{Type: f}
+		case map[string]interface{}:
+			format := IDFormat{}
+			if t, ok := f["type"].(string); ok {
+				format.Type = t
+			}
+			if pattern, ok := f["pattern"].(string); ok {
+				format.Pattern = pattern
+			}
+			formats[resourceType] = format
+		}
+	}
+	return formats
+}
+
+// parseMilestones reads {"views": [100, 1000]}; non-integer thresholds are
+// skipped.
+func parseMilestones(raw map[string]interface{}) map[string][]int {
+	milestones := make(map[string][]int, len(raw))
+	for key, v := range raw {
+		values, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		thresholds := make([]int, 0, len(values))
+		for _, value := range values {
+			if threshold, ok := value.(int); ok {
+				thresholds = append(thresholds, threshold)
+			}
+		}
+		milestones[key] = thresholds
+	}
+	return milestones
+}
+
+// parseRateLimits reads {"ip": {"requests_per_second": 5, "burst": 20}}.
+func parseRateLimits(raw map[string]interface{}) map[string]RateLimit {
+	limits := make(map[string]RateLimit, len(raw))
+	for scope, v := range raw {
+		l, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		limit := RateLimit{}
+		switch rps := l["requests_per_second"].(type) {
+		case float64:
+			limit.RequestsPerSecond = rps
+		case int:
+			limit.RequestsPerSecond = float64(rps)
+		}
+		if burst, ok := l["burst"].(int); ok {
+			limit.Burst = burst
+		}
+		limits[scope] = limit
+	}
+	return limits
+}
+
+// parseTenantQuota reads {"max_metrics": 10000, "writes_per_second": 50,
+// "write_burst": 200}.
+func parseTenantQuota(raw map[string]interface{}) TenantQuota {
+	quota := TenantQuota{}
+	if maxMetrics, ok := raw["max_metrics"].(int); ok {
+		quota.MaxMetrics = maxMetrics
+	}
+	switch wps := raw["writes_per_second"].(type) {
+	case float64:
+		quota.WritesPerSecond = wps
+	case int:
+		quota.WritesPerSecond = float64(wps)
+	}
+	if burst, ok := raw["write_burst"].(int); ok {
+		quota.WriteBurst = burst
+	}
+	return quota
+}
+
+// parseRouteMetrics accepts both the short form ({"GET /posts/:id":
+// "post/:id/views"}) and the full form ({"GET /posts/:id": {"metric":
+// "post/:id/views", "statuses": [200], "sample_rate": 0.1}}).
+func parseRouteMetrics(raw map[string]interface{}) map[string]RouteMetric {
+	routes := make(map[string]RouteMetric, len(raw))
+	for route, v := range raw {
+		switch r := v.(type) {
+		case string:
+			routes[route] = RouteMetric{Metric: r}
+		case map[string]interface{}:
+			rm := RouteMetric{}
+			if metric, ok := r["metric"].(string); ok {
+				rm.Metric = metric
+			}
+			if statuses, ok := r["statuses"].([]interface{}); ok {
+				for _, status := range statuses {
+					if code, ok := status.(int); ok {
+						rm.Statuses = append(rm.Statuses, code)
+					}
+				}
+			}
+			switch rate := r["sample_rate"].(type) {
+			case float64:
+				rm.SampleRate = rate
+			case int:
+				rm.SampleRate = float64(rate)
+			}
+			routes[route] = rm
+		}
+	}
+	return routes
+}
+
+// Handler increments the metrics configured in route_metrics once the matched
+// route has answered. Without route_metrics it only calls the next handler.
 func (p *MetricsPlugin) Handler() fiber.Handler {
 	return func(c fiber.Ctx) error {
-		return c.Next()
+		if err := c.Next(); err != nil {
+			return err
+		}
+		p.recordRoute(c)
+		return nil
 	}
 }
 
@@ -77,19 +307,41 @@ func (p *MetricsPlugin) SetupEndpoints(router fiber.Router) error {
 		return nil
 	}
 
-	p.writer = newBatchWriter(p.db, batchWriterOptions{})
-	RegisterRoutes(router, p.db, &p.config, p.writer)
+	p.hub = newMetricHub(p.config.StreamBufferSize)
+	p.alerts = newAlertEvaluator(p.db, alertEvaluatorOptions{})
+	p.milestones = newMilestoneTracker(p.db, p.config.Milestones, &p.milestoneListeners)
+	p.writer = newBatchWriter(p.db, batchWriterOptions{
+		flushListeners: []func([]Metric){p.hub.publish, p.events.publishPersisted, p.alerts.evaluate, p.milestones.check},
+	})
+	p.dedup = newDedupFilter(p.db, &p.config)
+	p.quotas = newTenantQuotas(p.db, &p.config)
+	RegisterRoutes(router, p.db, &p.config, p.writer, p.hub, &p.events, p.dedup, newRateLimiter(p.config.RateLimits, &p.stats), p.quotas)
 	return nil
 }
 
 // Close flushes any buffered metrics and stops the background writer. Hosts
 // must call it during graceful shutdown (after the HTTP server has drained) so
-// no accepted metric is lost.
+// no accepted metric is lost. Open metric streams are ended, and queued alert
+// webhooks, milestone and subscriber events delivered, afterwards.
 func (p *MetricsPlugin) Close(ctx context.Context) error {
 	if p.writer == nil {
 		return nil
 	}
-	return p.writer.shutdown(ctx)
+	err := p.writer.shutdown(ctx)
+	p.hub.close()
+	if alertErr := p.alerts.shutdown(ctx); err == nil {
+		err = alertErr
+	}
+	if milestoneErr := p.milestones.shutdown(ctx); err == nil {
+		err = milestoneErr
+	}
+	if eventsErr := p.events.close(ctx); err == nil {
+		err = eventsErr
+	}
+	if dedupErr := p.dedup.shutdown(ctx); err == nil {
+		err = dedupErr
+	}
+	return err
 }
 
 func (p *MetricsPlugin) MigrationSource() interface{} {
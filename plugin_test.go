@@ -3,6 +3,7 @@ package metrics
 import (
 	"testing"
 
+	"github.com/gofiber/fiber/v3"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -61,6 +62,152 @@ func TestMetricsPlugin_Initialize(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "resource id formats",
+			config: map[string]interface{}{
+				"allowed_types": []interface{}{"post", "user", "tag"},
+				"resource_id_formats": map[string]interface{}{
+					"user": "int",
+					"tag":  map[string]interface{}{"type": "string", "pattern": "[a-z0-9-]+"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "milestones",
+			config: map[string]interface{}{
+				"milestones": map[string]interface{}{"views": []interface{}{100, 1000}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid milestones",
+			config: map[string]interface{}{
+				"milestones": map[string]interface{}{"views": []interface{}{-1}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route metrics",
+			config: map[string]interface{}{
+				"route_metrics": map[string]interface{}{
+					"GET /posts/:id": "post/:id/views",
+					"POST /posts/:id/share": map[string]interface{}{
+						"metric":      "post/:id/shares",
+						"statuses":    []interface{}{200, 201},
+						"sample_rate": 0.1,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid route metrics",
+			config: map[string]interface{}{
+				"route_metrics": map[string]interface{}{"GET /posts/:id": "views"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unique keys",
+			config: map[string]interface{}{
+				"unique_keys": []interface{}{"viewers"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "dedup",
+			config: map[string]interface{}{
+				"dedup_window_seconds": 300,
+				"dedup_cache_size":     1000,
+				"dedup_store":          "database",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid dedup store",
+			config: map[string]interface{}{
+				"dedup_store": "redis",
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate limits",
+			config: map[string]interface{}{
+				"rate_limits": map[string]interface{}{
+					"ip":     map[string]interface{}{"requests_per_second": 5, "burst": 20},
+					"metric": map[string]interface{}{"requests_per_second": 0.5},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "tenant quotas",
+			config: map[string]interface{}{
+				"tenant_quota": map[string]interface{}{"max_metrics": 1000, "writes_per_second": 10},
+				"tenant_quotas": map[string]interface{}{
+					"acme": map[string]interface{}{"max_metrics": 100000, "writes_per_second": 100.5, "write_burst": 500},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid tenant quota",
+			config: map[string]interface{}{
+				"tenant_quota": map[string]interface{}{"writes_per_second": -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "authorizer and admin role",
+			config: map[string]interface{}{
+				"authorizer": AuthorizerFunc(func(fiber.Ctx, AuthorizationRequest) error { return nil }),
+				"admin_role": "ops",
+			},
+			wantErr: false,
+		},
+		{
+			name: "tenant header",
+			config: map[string]interface{}{
+				"tenant_header": "X-Tenant",
+			},
+			wantErr: false,
+		},
+		{
+			name: "tenant extractor",
+			config: map[string]interface{}{
+				"tenant_extractor": func(fiber.Ctx) (string, error) { return "acme", nil },
+			},
+			wantErr: false,
+		},
+		{
+			name: "key visibility",
+			config: map[string]interface{}{
+				"key_visibility": map[string]interface{}{"revenue": "private"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid key visibility",
+			config: map[string]interface{}{
+				"key_visibility": map[string]interface{}{"revenue": "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rate limits",
+			config: map[string]interface{}{
+				"rate_limits": map[string]interface{}{"ip": map[string]interface{}{"burst": 20}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid resource id format",
+			config: map[string]interface{}{
+				"resource_id_formats": map[string]interface{}{"post": "ulid"},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+// TenantQuota caps what one tenant may store and write, through POST /metrics
+// and the Recorder alike. Zero fields are unlimited.
+type TenantQuota struct {
+	// MaxMetrics caps the distinct metric rows of the tenant, soft deleted
+	// ones included. Writes creating new metrics beyond it are rejected;
+	// existing metrics can still be incremented.
+	MaxMetrics int `json:"max_metrics" yaml:"max_metrics"`
+	// WritesPerSecond and WriteBurst form a token bucket shared by every
+	// write of the tenant. A zero WriteBurst defaults to one second of
+	// writes (at least one).
+	WritesPerSecond float64 `json:"writes_per_second" yaml:"writes_per_second"`
+	WriteBurst      int     `json:"write_burst,omitempty" yaml:"write_burst,omitempty"`
+}
+
+func (q TenantQuota) writeLimit() RateLimit {
+	return RateLimit{RequestsPerSecond: q.WritesPerSecond, Burst: q.WriteBurst}
+}
+
+func (q TenantQuota) validate(field string) error {
+	if q.MaxMetrics < 0 {
+		return fmt.Errorf("%s: max_metrics must be 0 (unlimited) or positive", field)
+	}
+	if q.WritesPerSecond < 0 {
+		return fmt.Errorf("%s: writes_per_second must be 0 (unlimited) or positive", field)
+	}
+	if q.WriteBurst < 0 {
+		return fmt.Errorf("%s: write_burst must be 0 (default) or positive", field)
+	}
+	return nil
+}
+
+// quotaFor returns the quota of tenant: its tenant_quotas entry if any, else
+// tenant_quota. An entry replaces tenant_quota as a whole.
+func (c *Config) quotaFor(tenant string) TenantQuota {
+	if quota, ok := c.TenantQuotas[tenant]; ok {
+		return quota
+	}
+	return c.TenantQuota
+}
+
+// TenantUsageDTO is the answer of GET /metrics/quota. Limits are omitted when
+// unlimited; WritesAvailable is the number of writes the tenant can make
+// right now.
+type TenantUsageDTO struct {
+	Tenant          string  `json:"tenant"`
+	Metrics         int     `json:"metrics"`
+	MaxMetrics      int     `json:"maxMetrics,omitempty"`
+	WritesPerSecond float64 `json:"writesPerSecond,omitempty"`
+	WriteBurst      int     `json:"writeBurst,omitempty"`
+	WritesAvailable *int    `json:"writesAvailable,omitempty"`
+}
+
+// tenantQuotas enforces Config.TenantQuota and Config.TenantQuotas on every
+// write path: POST /metrics, the Recorder and route metrics. Write buckets are
+// kept per tenant and dropped once idle long enough to be full again. A nil
+// tenantQuotas enforces nothing.
+type tenantQuotas struct {
+	db     database.Database
+	config *Config
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newTenantQuotas(db database.Database, config *Config) *tenantQuotas {
+	return &tenantQuotas{
+		db:      db,
+		config:  config,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// admit applies the quotas of m's tenant to a write of m: over the write rate
+// it is refused with 429 and how long to wait for the next token, and when it
+// would create a metric beyond max_metrics with 403.
+func (q *tenantQuotas) admit(ctx context.Context, m Metric) (time.Duration, error) {
+	if q == nil {
+		return 0, nil
+	}
+	if wait, ok := q.allowWrite(m.Tenant); !ok {
+		return wait, fiber.NewError(fiber.StatusTooManyRequests, "tenant write quota exceeded")
+	}
+	return 0, q.checkStorage(ctx, m)
+}
+
+// allowWrite takes a token from the tenant's write bucket, or returns how long
+// to wait for one.
+func (q *tenantQuotas) allowWrite(tenant string) (time.Duration, bool) {
+	limit := q.config.quotaFor(tenant).writeLimit()
+	if limit.RequestsPerSecond == 0 {
+		return 0, true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	if now.Sub(q.lastSweep) >= rateLimitSweepEvery {
+		q.lastSweep = now
+		sweepBuckets(q.buckets, now)
+	}
+
+	b := refillBucket(q.buckets, tenant, limit, now)
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / limit.RequestsPerSecond * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// checkStorage rejects m when it is a new metric and its tenant already
+// stores MaxMetrics of them. Only persisted rows are counted, so new metrics
+// created within one flush interval may overshoot the quota slightly.
+func (q *tenantQuotas) checkStorage(ctx context.Context, m Metric) error {
+	max := q.config.quotaFor(m.Tenant).MaxMetrics
+	if max == 0 {
+		return nil
+	}
+
+	existing, err := fetchMetric(ctx, q.db, m)
+	if err != nil || existing != nil {
+		return err
+	}
+
+	count, err := countTenantMetrics(ctx, q.db, m.Tenant)
+	if err != nil {
+		return err
+	}
+	if count >= max {
+		return fiber.NewError(fiber.StatusForbidden,
+			fmt.Sprintf("tenant metric quota exceeded: %d of %d metrics stored", count, max))
+	}
+	return nil
+}
+
+// usage reports what tenant stores and may still write.
+func (q *tenantQuotas) usage(ctx context.Context, tenant string) (TenantUsageDTO, error) {
+	count, err := countTenantMetrics(ctx, q.db, tenant)
+	if err != nil {
+		return TenantUsageDTO{}, err
+	}
+
+	quota := q.config.quotaFor(tenant)
+	usage := TenantUsageDTO{
+		Tenant:          tenant,
+		Metrics:         count,
+		MaxMetrics:      quota.MaxMetrics,
+		WritesPerSecond: quota.WritesPerSecond,
+		WriteBurst:      quota.WriteBurst,
+	}
+
+	if limit := quota.writeLimit(); limit.RequestsPerSecond > 0 {
+		q.mu.Lock()
+		available := int(math.Floor(refillBucket(q.buckets, tenant, limit, q.now()).tokens))
+		q.mu.Unlock()
+		usage.WritesAvailable = &available
+	}
+
+	return usage, nil
+}
+
+// countTenantMetrics counts the metric rows of tenant. Soft deleted metrics
+// still occupy a row and can be restored, so they are counted.
+func countTenantMetrics(ctx context.Context, db database.Database, tenant string) (int, error) {
+	sqlStr, args, err := query.New(db.Dialect()).
+		Select().
+		SelectExpr(query.Count(query.RawExpr("*"))).
+		From(Metric{}.TableName()).
+		Where(query.Eq("tenant", tenant)).
+		Build()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := db.QueryRow(ctx, sqlStr, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Quota answers GET /metrics/quota with the caller's tenant usage and limits.
+func (r *MetricResource) Quota(c fiber.Ctx) error {
+	if err := r.hooks.config.authorize(c, AuthorizationRequest{Operation: OperationRead}); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	tenant, err := r.hooks.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	usage, err := r.quotas.usage(auth.Context(c), tenant)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getAll")
+	}
+	return response.SendFormatted(c, fiber.StatusOK, usage)
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_QuotaFor(t *testing.T) {
+	config := DefaultConfig()
+	config.TenantQuota = TenantQuota{MaxMetrics: 10, WritesPerSecond: 1}
+	config.TenantQuotas = map[string]TenantQuota{"acme": {MaxMetrics: 100}}
+
+	assert.Equal(t, TenantQuota{MaxMetrics: 10, WritesPerSecond: 1}, config.quotaFor("globex"))
+	assert.Equal(t, TenantQuota{MaxMetrics: 100}, config.quotaFor("acme"), "an entry replaces the default quota")
+}
+
+func TestTenantQuotas_AllowWrite(t *testing.T) {
+	config := DefaultConfig()
+	config.TenantQuota = TenantQuota{WritesPerSecond: 1, WriteBurst: 2}
+	config.TenantQuotas = map[string]TenantQuota{"acme": {}}
+	quotas := newTenantQuotas(nil, &config)
+	clock := &fakeClock{now: time.Now()}
+	quotas.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		_, ok := quotas.allowWrite("globex")
+		require.True(t, ok)
+	}
+	wait, ok := quotas.allowWrite("globex")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	for i := 0; i < 10; i++ {
+		_, ok := quotas.allowWrite("acme")
+		require.True(t, ok, "acme has no write quota")
+	}
+
+	clock.Advance(time.Second)
+	_, ok = quotas.allowWrite("globex")
+	assert.True(t, ok)
+}
+
+func TestTenantQuotas_AllowWriteKeepsBucketKeys(t *testing.T) {
+	config := DefaultConfig()
+	config.TenantQuota = TenantQuota{WritesPerSecond: 0.001, WriteBurst: 1}
+	quotas := newTenantQuotas(nil, &config)
+
+	// A tenant aliasing a request buffer, which is rewritten by the next
+	// request once this one is done.
+	buf := []byte("acme")
+	_, ok := quotas.allowWrite(unsafe.String(&buf[0], len(buf)))
+	require.True(t, ok)
+	copy(buf, "zzzz")
+
+	_, ok = quotas.allowWrite("acme")
+	assert.False(t, ok, "acme already spent its burst")
+	_, ok = quotas.allowWrite("zzzz")
+	assert.True(t, ok)
+}
+
+func TestMetricResource_TenantQuotas(t *testing.T) {
+	rec, seed := newTestRecorder(t, DefaultConfig())
+	db := rec.db
+	ctx := context.Background()
+	resourceID := uuid.New().String()
+	require.NoError(t, rec.WithTenant("acme").Set("post", resourceID, "views", 3))
+	require.NoError(t, seed.shutdown(ctx))
+
+	config := DefaultConfig()
+	config.TenantHeader = testTenantHeader
+	config.TenantQuotas = map[string]TenantQuota{
+		"acme":   {MaxMetrics: 1},
+		"globex": {WritesPerSecond: 0.001, WriteBurst: 1},
+	}
+	require.NoError(t, config.Validate())
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(ctx) })
+
+	newApp := func(tenant string) *fiber.App {
+		app := fiber.New()
+		app.Use(asTenant(tenant))
+		RegisterMetricRoutes(app, db, &config, writer, nil, nil, nil, nil)
+		return app
+	}
+	acme, globex := newApp("acme"), newApp("globex")
+
+	status, body := doJSON(t, acme, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "likes", Value: 1,
+	})
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+	assert.Contains(t, string(body), "tenant metric quota exceeded: 1 of 1 metrics stored")
+
+	status, body = doJSON(t, acme, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "views", Value: 1,
+	})
+	assert.Equal(t, http.StatusCreated, status, "existing metrics stay writable: %s", body)
+
+	status, body = doJSON(t, acme, http.MethodGet, "/metrics/quota", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var usage TenantUsageDTO
+	require.NoError(t, json.Unmarshal(body, &usage))
+	assert.Equal(t, TenantUsageDTO{Tenant: "acme", Metrics: 1, MaxMetrics: 1}, usage)
+
+	status, body = doJSON(t, globex, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "likes", Value: 1,
+	})
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	status, body = doJSON(t, globex, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "likes", Value: 1,
+	})
+	assert.Equal(t, http.StatusTooManyRequests, status, string(body))
+	assert.Contains(t, string(body), "tenant write quota exceeded")
+
+	status, body = doJSON(t, globex, http.MethodGet, "/metrics/quota", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	usage = TenantUsageDTO{}
+	require.NoError(t, json.Unmarshal(body, &usage))
+	require.NotNil(t, usage.WritesAvailable)
+	assert.Equal(t, 0, *usage.WritesAvailable)
+	assert.Equal(t, 0.001, usage.WritesPerSecond)
+}
+
+func TestRecorder_TenantQuotas(t *testing.T) {
+	config := DefaultConfig()
+	config.TenantQuotas = map[string]TenantQuota{
+		"acme":   {MaxMetrics: 1},
+		"globex": {WritesPerSecond: 0.001, WriteBurst: 1},
+	}
+	require.NoError(t, config.Validate())
+	rec, w := newTestRecorder(t, config)
+	t.Cleanup(func() { _ = w.shutdown(context.Background()) })
+
+	resourceID := uuid.New().String()
+	_, err := rec.db.Exec(context.Background(),
+		rebind(rec.db, "INSERT INTO metrics (id, tenant, resource, resource_id, name, value) VALUES (?, ?, ?, ?, ?, ?)"),
+		uuid.New().String(), "acme", "post", resourceID, "views", 1)
+	require.NoError(t, err)
+
+	acme := rec.WithTenant("acme")
+	assert.NoError(t, acme.Increment("post", resourceID, "views", 1), "existing metrics stay writable")
+	assert.EqualError(t, acme.Increment("post", resourceID, "likes", 1), "tenant metric quota exceeded: 1 of 1 metrics stored")
+	assert.EqualError(t, acme.Set("post", resourceID, "likes", 1), "tenant metric quota exceeded: 1 of 1 metrics stored")
+
+	globex := rec.WithTenant("globex")
+	assert.NoError(t, globex.Increment("post", resourceID, "views", 1))
+	assert.EqualError(t, globex.Increment("post", resourceID, "views", 1), "tenant write quota exceeded")
+	assert.NoError(t, rec.Increment("post", resourceID, "views", 1), "other tenants are unaffected")
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
+)
+
+// Scopes a rate limit can be keyed by.
+const (
+	// RateLimitByIP limits each client IP address.
+	RateLimitByIP = "ip"
+	// RateLimitByUser limits each authenticated user; anonymous requests are
+	// not limited by it.
+	RateLimitByUser = "user"
+	// RateLimitByMetric limits each (resource, resourceId, key) tuple.
+	RateLimitByMetric = "metric"
+)
+
+// rateLimitScopes lists the scopes in the order they are checked.
+var rateLimitScopes = []string{RateLimitByIP, RateLimitByUser, RateLimitByMetric}
+
+const rateLimitSweepEvery = time.Minute
+
+// RateLimit is a token bucket: Burst requests can be made at once, and the
+// bucket refills at RequestsPerSecond. A zero Burst defaults to one second of
+// requests (at least one).
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             int     `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.RequestsPerSecond))
+}
+
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter enforces Config.RateLimits on the create path. Buckets are kept
+// per scope and key, and dropped once idle long enough to be full again. A nil
+// limiter allows everything.
+type rateLimiter struct {
+	limits map[string]RateLimit
+	stats  *pluginStats
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns nil when no rate limit is configured.
+func newRateLimiter(limits map[string]RateLimit, stats *pluginStats) *rateLimiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limits:  limits,
+		stats:   stats,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from every bucket the request falls in, or from none of
+// them if one is empty, in which case it returns how long to wait for it.
+func (l *rateLimiter) allow(c fiber.Ctx, m Metric) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var take []*tokenBucket
+	for _, scope := range rateLimitScopes {
+		limit, ok := l.limits[scope]
+		if !ok {
+			continue
+		}
+		key, ok := rateLimitKey(c, m, scope)
+		if !ok {
+			continue
+		}
+
+		b := l.refill(scope+"\x00"+key, limit, now)
+		if b.tokens < 1 {
+			l.stats.rateLimited(scope)
+			wait := time.Duration((1 - b.tokens) / limit.RequestsPerSecond * float64(time.Second))
+			return wait, false
+		}
+		take = append(take, b)
+	}
+
+	for _, b := range take {
+		b.tokens--
+	}
+	return 0, true
+}
+
+func (l *rateLimiter) refill(key string, limit RateLimit, now time.Time) *tokenBucket {
+	return refillBucket(l.buckets, key, limit, now)
+}
+
+// refillBucket returns the bucket stored under key, created full or topped up
+// with the tokens earned since it was last used. The map outlives the request
+// key may point into, so new keys are stored as a copy.
+func refillBucket(buckets map[string]*tokenBucket, key string, limit RateLimit, now time.Time) *tokenBucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &tokenBucket{limit: limit, tokens: limit.burst(), updated: now}
+		buckets[strings.Clone(key)] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(limit.burst(), b.tokens+elapsed*limit.RequestsPerSecond)
+	b.updated = now
+	return b
+}
+
+// sweep forgets buckets that have refilled completely, which behave exactly
+// like new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepEvery {
+		return
+	}
+	l.lastSweep = now
+	sweepBuckets(l.buckets, now)
+}
+
+func sweepBuckets(buckets map[string]*tokenBucket, now time.Time) {
+	for key, b := range buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.RequestsPerSecond >= b.limit.burst() {
+			delete(buckets, key)
+		}
+	}
+}
+
+func rateLimitKey(c fiber.Ctx, m Metric, scope string) (string, bool) {
+	switch scope {
+	case RateLimitByIP:
+		return c.IP(), true
+	case RateLimitByUser:
+		user := auth.GetAuthenticatedUser(c)
+		if user == nil || user.UserID == "" {
+			return "", false
+		}
+		return user.UserID, true
+	default:
+		return m.Tenant + "\x00" + m.Resource + "\x00" + m.ResourceId + "\x00" + m.Key, true
+	}
+}
+
+// retryAfter formats wait for the Retry-After header, in whole seconds.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest/auth/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRateLimitApp serves GET /:resourceId through limiter, authenticating the
+// caller named in the X-User header.
+func newRateLimitApp(limiter *rateLimiter) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c fiber.Ctx) error {
+		if user := c.Get("X-User"); user != "" {
+			authcontext.SetUserID(c, user)
+		}
+		return c.Next()
+	})
+	app.Get("/:resourceId", func(c fiber.Ctx) error {
+		m := Metric{Resource: "post", ResourceId: c.Params("resourceId"), Key: "views"}
+		if wait, ok := limiter.allow(c, m); !ok {
+			c.Set(fiber.HeaderRetryAfter, retryAfter(wait))
+			return c.SendStatus(fiber.StatusTooManyRequests)
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func rateLimited(t *testing.T, app *fiber.App, resourceID, user string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/"+resourceID, nil)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter)
+}
+
+func TestRateLimiter_RefillsAtTheConfiguredRate(t *testing.T) {
+	var stats pluginStats
+	limiter := newRateLimiter(map[string]RateLimit{
+		RateLimitByMetric: {RequestsPerSecond: 0.5, Burst: 2},
+	}, &stats)
+	clock := &fakeClock{now: time.Now()}
+	limiter.now = clock.Now
+	app := newRateLimitApp(limiter)
+	id := uuid.New().String()
+
+	status, _ := rateLimited(t, app, id, "")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = rateLimited(t, app, id, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, retry := rateLimited(t, app, id, "")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, "2", retry)
+
+	status, _ = rateLimited(t, app, uuid.New().String(), "")
+	assert.Equal(t, http.StatusOK, status, "each metric has its own bucket")
+
+	clock.Advance(time.Second)
+	status, retry = rateLimited(t, app, id, "")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, "1", retry)
+
+	clock.Advance(time.Second)
+	status, _ = rateLimited(t, app, id, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	assert.Equal(t, uint64(2), stats.rateLimitedByMetric.Load())
+}
+
+func TestRateLimiter_ScopesConsumeTogether(t *testing.T) {
+	var stats pluginStats
+	limiter := newRateLimiter(map[string]RateLimit{
+		RateLimitByUser:   {RequestsPerSecond: 1, Burst: 1},
+		RateLimitByMetric: {RequestsPerSecond: 1, Burst: 2},
+	}, &stats)
+	clock := &fakeClock{now: time.Now()}
+	limiter.now = clock.Now
+	app := newRateLimitApp(limiter)
+	id := uuid.New().String()
+
+	status, _ := rateLimited(t, app, id, "alice")
+	assert.Equal(t, http.StatusOK, status)
+	// Rejected by alice's bucket: the metric's bucket keeps its token.
+	status, _ = rateLimited(t, app, id, "alice")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	status, _ = rateLimited(t, app, id, "bob")
+	assert.Equal(t, http.StatusOK, status)
+	// Anonymous callers skip the user limit but not the metric one.
+	status, _ = rateLimited(t, app, id, "")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	assert.Equal(t, uint64(1), stats.rateLimitedByUser.Load())
+	assert.Equal(t, uint64(1), stats.rateLimitedByMetric.Load())
+	assert.Zero(t, stats.rateLimitedByIP.Load())
+}
+
+func TestRateLimiter_SweepsFullBuckets(t *testing.T) {
+	limiter := newRateLimiter(map[string]RateLimit{
+		RateLimitByIP: {RequestsPerSecond: 10},
+	}, &pluginStats{})
+	clock := &fakeClock{now: time.Now()}
+	limiter.now = clock.Now
+	app := newRateLimitApp(limiter)
+
+	status, _ := rateLimited(t, app, uuid.New().String(), "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, limiter.buckets, 1)
+
+	clock.Advance(rateLimitSweepEvery)
+	status, _ = rateLimited(t, app, uuid.New().String(), "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, limiter.buckets, 1, "the refilled bucket was dropped before a new one was taken")
+}
+
+func TestNewRateLimiter_DisabledWithoutLimits(t *testing.T) {
+	limiter := newRateLimiter(nil, &pluginStats{})
+	assert.Nil(t, limiter)
+
+	status, _ := rateLimited(t, newRateLimitApp(limiter), uuid.New().String(), "")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestMetricsPlugin_RateLimitsCreates(t *testing.T) {
+	p := &MetricsPlugin{}
+	require.NoError(t, p.Initialize(map[string]interface{}{
+		"database": newTestDB(t),
+		"rate_limits": map[string]interface{}{
+			"ip": map[string]interface{}{"requests_per_second": 1, "burst": 3},
+		},
+	}))
+	app := fiber.New()
+	require.NoError(t, p.SetupEndpoints(app))
+	defer func() { _ = p.Close(context.Background()) }()
+
+	var statuses []int
+	var retry string
+	for i := 0; i < 5; i++ {
+		raw, err := json.Marshal(MetricCreateDTO{
+			Resource: "post", ResourceId: uuid.New().String(), Key: "views", Value: 1,
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader(raw))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retry = resp.Header.Get(fiber.HeaderRetryAfter)
+		}
+	}
+
+	assert.Equal(t, []int{201, 201, 201, 429, 429}, statuses)
+	assert.Equal(t, "1", retry)
+	assert.Equal(t, map[string]uint64{"ip": 2, "user": 0, "metric": 0}, p.Stats().RateLimited)
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// Recorder writes metrics from Go code with the same validation as the HTTP
+// API. Writes go through the background batch writer, so they return before
+// the metric is persisted and are visible to Get after the next flush. It acts
+// for the default tenant unless scoped with WithTenant, whose quotas its writes
+// count against.
+type Recorder struct {
+	db     database.Database
+	hooks  *MetricHooks
+	writer *batchWriter
+	quotas *tenantQuotas
+	tenant string
+}
+
+// Recorder returns the plugin's Recorder. It is nil until SetupEndpoints has
+// run with a database.
+func (p *MetricsPlugin) Recorder() *Recorder {
+	if p.writer == nil {
+		return nil
+	}
+	return &Recorder{
+		db:     p.db,
+		hooks:  NewMetricHooks(&p.config),
+		writer: p.writer,
+		quotas: p.quotas,
+	}
+}
+
+// WithTenant returns a Recorder reading and writing the metrics of tenant.
+func (r *Recorder) WithTenant(tenant string) *Recorder {
+	scoped := *r
+	scoped.tenant = tenant
+	return &scoped
+}
+
+// Record creates a metric, like POST /metrics: if the metric already exists
+// the write is rejected when flushed.
+func (r *Recorder) Record(resourceType, resourceID, key string, value int) error {
+	return r.write(resourceType, resourceID, key, value, writeInsert)
+}
+
+// Increment adds delta to a metric, creating it at delta if needed. Concurrent
+// increments are summed, never lost.
+func (r *Recorder) Increment(resourceType, resourceID, key string, delta int) error {
+	return r.write(resourceType, resourceID, key, delta, writeIncrement)
+}
+
+// Set overwrites the value of a metric, creating it if needed.
+func (r *Recorder) Set(resourceType, resourceID, key string, value int) error {
+	return r.write(resourceType, resourceID, key, value, writeSet)
+}
+
+// AddVisitor counts visitor for a key listed in unique_keys; the metric's value
+// becomes the approximate number of distinct visitors after the next flush.
+func (r *Recorder) AddVisitor(resourceType, resourceID, key, visitor string) error {
+	key, err := r.hooks.validateTarget(resourceType, resourceID, key)
+	if err != nil {
+		return err
+	}
+	if !r.hooks.config.IsUniqueKey(key) {
+		return fiber.NewError(400, "key is not a unique metric")
+	}
+	if err := r.hooks.validateVisitor(key, visitor, 0); err != nil {
+		return err
+	}
+	if err := validateTenant(r.tenant); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	m := Metric{
+		Id:         uuid.New().String(),
+		Tenant:     r.tenant,
+		Resource:   resourceType,
+		ResourceId: resourceID,
+		Key:        key,
+		CreatedAt:  &now,
+	}
+	if err := r.admit(m); err != nil {
+		return err
+	}
+	r.writer.enqueueUnique(m, visitor)
+	return nil
+}
+
+// Get returns the persisted value of a metric, or 0 if it does not exist or
+// is deleted.
+func (r *Recorder) Get(ctx context.Context, resourceType, resourceID, key string) (int, error) {
+	key, err := r.hooks.validateTarget(resourceType, resourceID, key)
+	if err != nil {
+		return 0, err
+	}
+
+	m, err := fetchMetric(ctx, r.db, Metric{Tenant: r.tenant, Resource: resourceType, ResourceId: resourceID, Key: key})
+	if err != nil || m == nil || m.DeletedAt != nil {
+		return 0, err
+	}
+	return m.Value, nil
+}
+
+// write validates like CreateHook; with only_positive_values a negative delta
+// is rejected like a negative value.
+func (r *Recorder) write(resourceType, resourceID, key string, value int, mode writeMode) error {
+	key, err := r.hooks.validateTarget(resourceType, resourceID, key)
+	if err != nil {
+		return err
+	}
+	if err := r.hooks.validateValue(value); err != nil {
+		return err
+	}
+	// Unique metrics only change through AddVisitor.
+	if err := r.hooks.validateVisitor(key, "", value); err != nil {
+		return err
+	}
+
+	if err := validateTenant(r.tenant); err != nil {
+		return err
+	}
+
+	m := Metric{
+		Id:         uuid.New().String(),
+		Tenant:     r.tenant,
+		Resource:   resourceType,
+		ResourceId: resourceID,
+		Key:        key,
+		Value:      value,
+	}
+	if err := r.admit(m); err != nil {
+		return err
+	}
+	r.writer.enqueueWrite(m, mode)
+	return nil
+}
+
+// admit applies the tenant quotas to a write of m. With max_metrics set it
+// looks m up, so the write waits on the database.
+func (r *Recorder) admit(m Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWriteTimeout)
+	defer cancel()
+
+	_, err := r.quotas.admit(ctx, m)
+	return err
+}
+
+// fetchMetric loads the metric identified by the tenant and tuple of target,
+// or nil if it does not exist. Deleted metrics are returned too, with
+// DeletedAt set.
+func fetchMetric(ctx context.Context, db database.Database, target Metric) (*Metric, error) {
+	sqlStr, args, err := query.New(db.Dialect()).
+		Select("id", "value", "version", "updated_at", "deleted_at").
+		From(Metric{}.TableName()).
+		Where(metricCondition(target)).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	m := Metric{Tenant: target.Tenant, Resource: target.Resource, ResourceId: target.ResourceId, Key: target.Key}
+	if err := rows.Scan(&m.Id, &m.Value, &m.Version, &m.UpdatedAt, &m.DeletedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
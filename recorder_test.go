@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRecorder(t *testing.T, config Config, listeners ...func([]Metric)) (*Recorder, *batchWriter) {
+	t.Helper()
+
+	db := newTestDB(t)
+	p := &MetricsPlugin{config: config, db: db}
+	p.quotas = newTenantQuotas(db, &p.config)
+	p.writer = newBatchWriter(db, batchWriterOptions{
+		flushInterval:  time.Hour,
+		flushListeners: listeners,
+	})
+	return p.Recorder(), p.writer
+}
+
+func TestMetricsPlugin_RecorderRequiresSetup(t *testing.T) {
+	assert.Nil(t, (&MetricsPlugin{}).Recorder())
+}
+
+func TestRecorder_IncrementAndSet(t *testing.T) {
+	var persisted []Metric
+	rec, w := newTestRecorder(t, DefaultConfig(), func(batch []Metric) {
+		persisted = append(persisted, batch...)
+	})
+	ctx := context.Background()
+	views := uuid.New().String()
+	likes := uuid.New().String()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, rec.Increment("post", views, "views", 2))
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, rec.Increment("post", likes, "likes", 5))
+	require.NoError(t, rec.Set("post", likes, "likes", 1))
+	require.NoError(t, rec.Increment("post", likes, " likes ", 1))
+
+	require.NoError(t, w.shutdown(ctx))
+
+	got, err := rec.Get(ctx, "post", views, "views")
+	require.NoError(t, err)
+	assert.Equal(t, 100, got)
+
+	got, err = rec.Get(ctx, "post", likes, "likes")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got, "set resets the value, later increments add to it")
+
+	values := map[string]int{}
+	for _, m := range persisted {
+		values[m.Key] = m.Value
+	}
+	assert.Equal(t, map[string]int{"views": 100, "likes": 2}, values, "listeners see resulting values")
+}
+
+func TestRecorder_IncrementAcrossFlushes(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{config: DefaultConfig(), db: db}
+	// One write per batch: the second increment hits the existing row.
+	p.writer = newBatchWriter(db, batchWriterOptions{batchSize: 1})
+	rec := p.Recorder()
+	ctx := context.Background()
+	id := uuid.New().String()
+
+	require.NoError(t, rec.Increment("post", id, "views", 1))
+	require.NoError(t, rec.Increment("post", id, "views", 1))
+	require.NoError(t, p.writer.shutdown(ctx))
+
+	got, err := rec.Get(ctx, "post", id, "views")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+	assert.Equal(t, 1, countMetrics(t, db))
+}
+
+func TestRecorder_RecordKeepsCreateSemantics(t *testing.T) {
+	rec, w := newTestRecorder(t, DefaultConfig())
+	ctx := context.Background()
+	id := uuid.New().String()
+
+	require.NoError(t, rec.Record("post", id, "views", 7))
+	require.NoError(t, rec.Record("post", id, "views", 9))
+	require.NoError(t, w.shutdown(ctx))
+
+	got, err := rec.Get(ctx, "post", id, "views")
+	require.NoError(t, err)
+	assert.Equal(t, 7, got)
+}
+
+func TestRecorder_Validation(t *testing.T) {
+	config := DefaultConfig()
+	config.OnlyPositiveValues = true
+	rec, w := newTestRecorder(t, config)
+	defer func() { _ = w.shutdown(context.Background()) }()
+	id := uuid.New().String()
+
+	assert.EqualError(t, rec.Record("comment", id, "views", 1), "resource type is not allowed")
+	assert.EqualError(t, rec.Increment("post", "42", "views", 1), "resourceId must be a valid UUID")
+	assert.EqualError(t, rec.Set("post", id, " ", 1), "key cannot be empty")
+	assert.EqualError(t, rec.Increment("post", id, "views", -1), "value must be positive")
+
+	got, err := rec.Get(context.Background(), "post", id, "views")
+	require.NoError(t, err)
+	assert.Equal(t, 0, got)
+
+	_, err = rec.Get(context.Background(), "comment", id, "views")
+	assert.Error(t, err)
+}
+
+func TestCoalesceWrites(t *testing.T) {
+	a := Metric{Resource: "post", ResourceId: "a", Key: "views"}
+	b := Metric{Resource: "post", ResourceId: "b", Key: "views"}
+	with := func(m Metric, v int) Metric {
+		m.Value = v
+		return m
+	}
+
+	got := coalesceWrites([]pendingWrite{
+		{metric: with(a, 1), mode: writeIncrement},
+		{metric: with(b, 5), mode: writeSet},
+		{metric: with(a, 2), mode: writeIncrement},
+		{metric: with(b, 1), mode: writeIncrement},
+		{metric: with(a, 10), mode: writeSet},
+		{metric: with(a, 3), mode: writeIncrement},
+	})
+
+	assert.Equal(t, []pendingWrite{
+		{metric: with(a, 13), mode: writeSet},
+		{metric: with(b, 6), mode: writeSet},
+	}, got)
+}
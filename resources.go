@@ -4,9 +4,11 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
 	"github.com/nicolasbonnici/gorest/crud"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/processor"
+	"github.com/nicolasbonnici/gorest/query"
 	"github.com/nicolasbonnici/gorest/response"
 )
 
@@ -14,16 +16,27 @@ const MaxFilterValuesPerField = 50
 
 type MetricResource struct {
 	processor    processor.Processor[Metric, MetricCreateDTO, MetricUpdateDTO, MetricResponseDTO]
+	db           database.Database
+	crud         *crud.CRUD[Metric]
 	converter    *MetricConverter
 	hooks        *MetricHooks
 	writer       *batchWriter
+	events       *eventBus
+	dedup        *dedupFilter
+	limiter      *rateLimiter
+	quotas       *tenantQuotas
 	errorHandler processor.ErrorHandler
 }
 
-func RegisterMetricRoutes(router fiber.Router, db database.Database, config *Config, writer *batchWriter) {
+// RegisterMetricRoutes mounts the /metrics CRUD routes. quotas is shared with
+// the plugin's Recorder; a nil one gets its own from config.
+func RegisterMetricRoutes(router fiber.Router, db database.Database, config *Config, writer *batchWriter, events *eventBus, dedup *dedupFilter, limiter *rateLimiter, quotas *tenantQuotas) {
 	metricCRUD := crud.New[Metric](db)
 	hooks := NewMetricHooks(config)
 	converter := &MetricConverter{}
+	if quotas == nil {
+		quotas = newTenantQuotas(db, config)
+	}
 
 	fieldMapping := map[string]string{
 		"id":         "id",
@@ -32,6 +45,7 @@ func RegisterMetricRoutes(router fiber.Router, db database.Database, config *Con
 		"name":       "name",
 		"value":      "value",
 		"createdAt":  "created_at",
+		"updatedAt":  "updated_at",
 	}
 
 	proc := processor.New(processor.ProcessorConfig[Metric, MetricCreateDTO, MetricUpdateDTO, MetricResponseDTO]{
@@ -41,7 +55,7 @@ func RegisterMetricRoutes(router fiber.Router, db database.Database, config *Con
 		PaginationLimit:    config.PaginationLimit,
 		PaginationMaxLimit: config.MaxPaginationLimit,
 		FieldMap:           fieldMapping,
-		AllowedFields:      []string{"id", "resource", "resourceId", "name", "value", "createdAt"},
+		AllowedFields:      []string{"id", "resource", "resourceId", "name", "value", "createdAt", "updatedAt"},
 	}).
 		WithCreateHook(hooks.CreateHook).
 		WithUpdateHook(hooks.UpdateHook).
@@ -49,14 +63,23 @@ func RegisterMetricRoutes(router fiber.Router, db database.Database, config *Con
 
 	res := &MetricResource{
 		processor:    proc,
+		db:           db,
+		crud:         metricCRUD,
 		converter:    converter,
 		hooks:        hooks,
 		writer:       writer,
+		events:       events,
+		dedup:        dedup,
+		limiter:      limiter,
+		quotas:       quotas,
 		errorHandler: &processor.DefaultErrorHandler{},
 	}
 
 	router.Get("/metrics", res.GetAll)
+	router.Get("/metrics/quota", res.Quota)
 	router.Get("/metrics/:id", res.GetByID)
+	router.Get("/metrics/:id/audit", res.Audit)
+	router.Post("/metrics/:id/restore", res.Restore)
 	router.Post("/metrics", res.Create)
 	router.Put("/metrics/:id", res.Update)
 	router.Delete("/metrics/:id", res.Delete)
@@ -65,6 +88,10 @@ func RegisterMetricRoutes(router fiber.Router, db database.Database, config *Con
 // Create records a metric without blocking on the database: it validates the
 // request synchronously (preserving the 400s the sync path returned) and hands
 // the row to the async batch writer, then answers 201 from the in-memory model.
+// Visitors of unique metrics are handed over by createUnique instead. An event
+// its actor already sent within the dedup window is answered 200 with the
+// metric and dropped; one over a rate limit or its tenant's write quota is
+// answered 429, and a new metric beyond its tenant's max_metrics 403.
 func (r *MetricResource) Create(c fiber.Ctx) error {
 	var dto MetricCreateDTO
 	if err := c.Bind().Body(&dto); err != nil {
@@ -77,29 +104,291 @@ func (r *MetricResource) Create(c fiber.Ctx) error {
 		return r.errorHandler.HandleError(c, err, "hook")
 	}
 
+	if err := r.authorize(c, OperationCreate, model); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
 	// The DB used to stamp created_at (TIMESTAMP(0)); mirror that precision so
 	// the response body stays shape-compatible with the synchronous path.
 	now := time.Now().UTC().Truncate(time.Second)
 	model.CreatedAt = &now
+	model.UpdatedAt = &now
+
+	if wait, ok := r.limiter.allow(c, model); !ok {
+		c.Set(fiber.HeaderRetryAfter, retryAfter(wait))
+		return r.errorHandler.HandleError(c, fiber.NewError(fiber.StatusTooManyRequests, "rate limit exceeded"), "hook")
+	}
+	if wait, err := r.quotas.admit(auth.Context(c), model); err != nil {
+		if wait > 0 {
+			c.Set(fiber.HeaderRetryAfter, retryAfter(wait))
+		}
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	if r.dedup.duplicate(auth.Context(c), model, dto.Actor) {
+		return r.sendDuplicate(c, model)
+	}
+
+	if r.hooks.config.IsUniqueKey(model.Key) {
+		return r.createUnique(c, dto, model)
+	}
 
 	r.writer.enqueue(model)
+	r.events.publish(MetricEvent{Type: EventMetricAccepted, Metric: model, OccurredAt: now})
 
 	dtoOut := r.converter.ModelToResponseDTO(model)
 	return response.SendFormatted(c, fiber.StatusCreated, dtoOut)
 }
 
+// createUnique queues a visitor of a unique metric and answers 202 with the
+// metric as last persisted: the count including this visitor is only known
+// once the writer has merged it into the sketch.
+func (r *MetricResource) createUnique(c fiber.Ctx, dto MetricCreateDTO, model Metric) error {
+	existing, err := fetchMetric(auth.Context(c), r.db, model)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+	if existing != nil {
+		model.Id = existing.Id
+		model.Value = existing.Value
+	}
+
+	r.writer.enqueueUnique(model, dto.Visitor)
+	r.events.publish(MetricEvent{Type: EventMetricAccepted, Metric: model, OccurredAt: *model.CreatedAt})
+
+	return response.SendFormatted(c, fiber.StatusAccepted, r.converter.ModelToResponseDTO(model))
+}
+
+// sendDuplicate answers a deduplicated create with the metric as stored, or as
+// submitted when the counted event has not been flushed yet.
+func (r *MetricResource) sendDuplicate(c fiber.Ctx, model Metric) error {
+	existing, err := fetchMetric(auth.Context(c), r.db, model)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+	if existing != nil {
+		model.Id = existing.Id
+		model.Value = existing.Value
+	}
+
+	return response.SendFormatted(c, fiber.StatusOK, r.converter.ModelToResponseDTO(model))
+}
+
+// GetByID answers with one metric once the caller is authorized to read its
+// resource type and key, and its version as ETag. A private metric the caller
+// may not read is answered 404, as if it did not exist.
 func (r *MetricResource) GetByID(c fiber.Ctx) error {
-	return r.processor.GetByID(c)
+	existing, err := r.load(c, c.Params("id"))
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+
+	if err := r.authorize(c, OperationRead, *existing); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+	if !r.hooks.config.canRead(c, *existing) {
+		return r.errorHandler.HandleError(c, fiber.NewError(404, "metric not found"), "getById")
+	}
+
+	c.Set(fiber.HeaderETag, etag(*existing))
+	return response.SendFormatted(c, fiber.StatusOK, r.converter.ModelToResponseDTO(*existing))
 }
 
 func (r *MetricResource) GetAll(c fiber.Ctx) error {
+	if err := r.authorize(c, OperationRead, Metric{}); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
 	return r.processor.GetAll(c)
 }
 
+// Update changes the value of an existing metric and records the change in
+// the audit trail. The stored row is loaded first so subscribers and the
+// trail get the value before and after the update, and the update only
+// applies to the version loaded. A request whose If-Match does not name that
+// version, or that loses the race against another write, is answered 412;
+// without If-Match, losing the race is answered 409.
 func (r *MetricResource) Update(c fiber.Ctx) error {
-	return r.processor.Update(c)
+	id := c.Params("id")
+
+	var dto MetricUpdateDTO
+	if err := c.Bind().Body(&dto); err != nil {
+		return r.errorHandler.HandleError(c, err, "parse")
+	}
+
+	model := r.converter.UpdateDTOToModel(dto)
+	if err := r.hooks.UpdateHook(c, dto, &model); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	ctx := auth.Context(c)
+	existing, err := r.load(c, id)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+	if err := r.authorize(c, OperationUpdate, *existing); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+	if r.hooks.config.IsUniqueKey(existing.Key) {
+		return r.errorHandler.HandleError(c, fiber.NewError(400, "unique metrics cannot be updated"), "hook")
+	}
+
+	stale := errMetricModified
+	if sent, ok := ifMatch(c.Get(fiber.HeaderIfMatch), *existing); sent {
+		if !ok {
+			return r.errorHandler.HandleError(c, errPreconditionFailed, "hook")
+		}
+		stale = errPreconditionFailed
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	updated := *existing
+	updated.Value = model.Value
+	updated.Version = existing.Version + 1
+	updated.UpdatedAt = &now
+	sqlStr, args, err := query.New(r.db.Dialect()).
+		Update(Metric{}.TableName()).
+		Set("value", updated.Value).
+		Set("version", updated.Version).
+		Set("updated_at", now).
+		Where(query.Eq("id", existing.Id)).
+		Where(query.Eq("version", existing.Version)).
+		Build()
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "update")
+	}
+
+	before, after := existing.Value, updated.Value
+	entry := newAuditEntry(c, OperationUpdate, *existing, &after)
+	if err := execAudited(ctx, r.db, entry, stale, sqlStr, args); err != nil {
+		return r.errorHandler.HandleError(c, err, "update")
+	}
+
+	r.events.publish(MetricEvent{
+		Type:       EventMetricUpdated,
+		Metric:     updated,
+		Before:     &before,
+		After:      &after,
+		OccurredAt: time.Now().UTC(),
+	})
+
+	c.Set(fiber.HeaderETag, etag(updated))
+	return response.SendFormatted(c, fiber.StatusOK, r.converter.ModelToResponseDTO(updated))
 }
 
+// Delete soft-deletes a metric and records it in the audit trail. The row, and
+// the sketches of a unique metric, are kept so POST /metrics/:id/restore can
+// bring the metric back; until then it is left out of every read. A delete
+// racing another write is answered 409.
 func (r *MetricResource) Delete(c fiber.Ctx) error {
-	return r.processor.Delete(c)
+	ctx := auth.Context(c)
+	existing, err := r.load(c, c.Params("id"))
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+
+	if err := r.authorize(c, OperationDelete, *existing); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sqlStr, args, err := query.New(r.db.Dialect()).
+		Update(Metric{}.TableName()).
+		Set("deleted_at", now).
+		Set("updated_at", now).
+		Set("version", existing.Version+1).
+		Where(query.Eq("id", existing.Id)).
+		Where(query.Eq("version", existing.Version)).
+		Build()
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "delete")
+	}
+	entry := newAuditEntry(c, OperationDelete, *existing, nil)
+	if err := execAudited(ctx, r.db, entry, errMetricModified, sqlStr, args); err != nil {
+		return r.errorHandler.HandleError(c, err, "delete")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Restore answers POST /metrics/:id/restore by bringing back a deleted metric,
+// with the value it has accumulated since, and recording it in the audit
+// trail. A restore racing another write is answered 409.
+func (r *MetricResource) Restore(c fiber.Ctx) error {
+	ctx := auth.Context(c)
+	existing, err := r.find(c, c.Params("id"))
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+
+	if err := r.authorize(c, OperationRestore, *existing); err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+	if existing.DeletedAt == nil {
+		return r.errorHandler.HandleError(c, fiber.NewError(fiber.StatusConflict, "metric is not deleted"), "hook")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sqlStr, args, err := query.New(r.db.Dialect()).
+		Update(Metric{}.TableName()).
+		Set("deleted_at", nil).
+		Set("updated_at", now).
+		Set("version", existing.Version+1).
+		Where(query.Eq("id", existing.Id)).
+		Where(query.Eq("version", existing.Version)).
+		Build()
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "update")
+	}
+	value := existing.Value
+	entry := newAuditEntry(c, OperationRestore, *existing, &value)
+	if err := execAudited(ctx, r.db, entry, errMetricModified, sqlStr, args); err != nil {
+		return r.errorHandler.HandleError(c, err, "update")
+	}
+
+	restored := *existing
+	restored.DeletedAt = nil
+	restored.UpdatedAt = &now
+	restored.Version++
+	c.Set(fiber.HeaderETag, etag(restored))
+	return response.SendFormatted(c, fiber.StatusOK, r.converter.ModelToResponseDTO(restored))
+}
+
+// load fetches metric id. Deleted metrics, and metrics of another tenant than
+// the caller's, are reported as not found.
+func (r *MetricResource) load(c fiber.Ctx, id string) (*Metric, error) {
+	m, err := r.find(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if m.DeletedAt != nil {
+		return nil, fiber.NewError(404, "metric not found")
+	}
+	return m, nil
+}
+
+// find is load for deleted metrics too.
+func (r *MetricResource) find(c fiber.Ctx, id string) (*Metric, error) {
+	tenant, err := r.hooks.config.tenant(c)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := r.crud.GetByID(auth.Context(c), id)
+	if err != nil {
+		return nil, err
+	}
+	if m.Tenant != tenant {
+		return nil, fiber.NewError(404, "metric not found")
+	}
+	return m, nil
+}
+
+// authorize checks op on m's resource type, ID and key.
+func (r *MetricResource) authorize(c fiber.Ctx, op string, m Metric) error {
+	return r.hooks.config.authorize(c, AuthorizationRequest{
+		Operation:    op,
+		ResourceType: m.Resource,
+		ResourceID:   m.ResourceId,
+		Key:          m.Key,
+	})
 }
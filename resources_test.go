@@ -1,9 +1,20 @@
 package metrics
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/query"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMaxFilterValuesPerField(t *testing.T) {
@@ -14,3 +25,240 @@ func TestMetricResource_Initialization(t *testing.T) {
 	resource := &MetricResource{}
 	assert.NotNil(t, resource)
 }
+
+func TestMetricResource_SoftDeleteAndRestore(t *testing.T) {
+	db := newTestDB(t)
+	m := seedMetric(t, db, "score", 10)
+	other := seedMetric(t, db, "likes", 1)
+	config := DefaultConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(context.Background()) })
+
+	admin := fiber.New()
+	admin.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(admin, db, &config, writer, nil, nil, nil, nil)
+	user := fiber.New()
+	user.Use(withRoles("user"))
+	RegisterMetricRoutes(user, db, &config, writer, nil, nil, nil, nil)
+
+	status, body := doJSON(t, admin, http.MethodDelete, "/metrics/"+m.Id, nil)
+	require.Equal(t, http.StatusNoContent, status, string(body))
+	assert.Equal(t, 2, countMetrics(t, db), "the row is kept")
+
+	status, body = doJSON(t, admin, http.MethodDelete, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+	status, body = doJSON(t, admin, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 1})
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), other.Id)
+	assert.NotContains(t, string(body), m.Id)
+
+	status, body = doJSON(t, user, http.MethodGet, "/metrics?withDeleted=true", nil)
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics?withDeleted=true", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), m.Id)
+	assert.Contains(t, string(body), `"deletedAt"`)
+
+	values, err := fetchMetricValues(context.Background(), db, "", "post", []string{m.ResourceId}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, values[m.ResourceId], "aggregates skip deleted metrics")
+
+	status, body = doJSON(t, user, http.MethodPost, "/metrics/"+m.Id+"/restore", nil)
+	assert.Equal(t, http.StatusForbidden, status, string(body))
+	status, body = doJSON(t, admin, http.MethodPost, "/metrics/"+other.Id+"/restore", nil)
+	assert.Equal(t, http.StatusConflict, status, string(body))
+
+	status, body = doJSON(t, admin, http.MethodPost, "/metrics/"+m.Id+"/restore", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var restored MetricResponseDTO
+	require.NoError(t, json.Unmarshal(body, &restored))
+	assert.Equal(t, 10, restored.Value)
+	assert.Nil(t, restored.DeletedAt)
+
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics/"+m.Id, nil)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	audit := getAudit(t, admin, "/metrics/"+m.Id+"/audit")
+	require.Len(t, audit.Member, 2)
+	assert.Equal(t, OperationRestore, audit.Member[0].Operation)
+	assert.Equal(t, OperationDelete, audit.Member[1].Operation)
+}
+
+func TestRecorder_DeletedMetricKeepsCounting(t *testing.T) {
+	var announced []Metric
+	rec, w := newTestRecorder(t, DefaultConfig(), func(batch []Metric) {
+		announced = append(announced, batch...)
+	})
+	ctx := context.Background()
+	resourceID := uuid.New().String()
+	require.NoError(t, rec.Set("post", resourceID, "views", 5))
+	require.NoError(t, w.shutdown(ctx))
+
+	_, err := rec.db.Exec(ctx, "UPDATE metrics SET deleted_at = CURRENT_TIMESTAMP")
+	require.NoError(t, err)
+
+	got, err := rec.Get(ctx, "post", resourceID, "views")
+	require.NoError(t, err)
+	assert.Zero(t, got, "deleted metrics read as missing")
+
+	rec.writer = newBatchWriter(rec.db, batchWriterOptions{
+		flushInterval:  time.Hour,
+		flushListeners: []func([]Metric){func(batch []Metric) { announced = append(announced, batch...) }},
+	})
+	require.NoError(t, rec.Increment("post", resourceID, "views", 2))
+	require.NoError(t, rec.writer.shutdown(ctx))
+	assert.Len(t, announced, 1, "writes to deleted metrics are not announced")
+
+	stored, err := fetchMetric(ctx, rec.db, Metric{Resource: "post", ResourceId: resourceID, Key: "views"})
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.NotNil(t, stored.DeletedAt)
+	assert.Equal(t, 7, stored.Value)
+}
+
+// doIfMatch is doJSON with an If-Match header, returning the ETag answered.
+func doIfMatch(t *testing.T, app *fiber.App, method, target, tag string, payload any) (int, string, []byte) {
+	t.Helper()
+
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		require.NoError(t, err)
+		body = bytes.NewReader(raw)
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	if tag != "" {
+		req.Header.Set(fiber.HeaderIfMatch, tag)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderETag), out
+}
+
+func TestMetricResource_OptimisticConcurrency(t *testing.T) {
+	db := newTestDB(t)
+	m := seedMetric(t, db, "reputation", 10)
+	config := DefaultConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(context.Background()) })
+
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(app, db, &config, writer, nil, nil, nil, nil)
+	target := "/metrics/" + m.Id
+
+	status, tag, body := doIfMatch(t, app, http.MethodGet, target, "", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Equal(t, `"1"`, tag)
+
+	// Two admins edit from the same version: the second one is refused.
+	status, tag, body = doIfMatch(t, app, http.MethodPut, target, `"1"`, MetricUpdateDTO{Value: 20})
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Equal(t, `"2"`, tag)
+	var updated MetricResponseDTO
+	require.NoError(t, json.Unmarshal(body, &updated))
+	assert.Equal(t, 2, updated.Version)
+
+	status, _, body = doIfMatch(t, app, http.MethodPut, target, `"1"`, MetricUpdateDTO{Value: 30})
+	assert.Equal(t, http.StatusPreconditionFailed, status, string(body))
+
+	// Increments through the writer bump the version too.
+	w := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	w.enqueueWrite(Metric{Id: uuid.New().String(), Resource: m.Resource, ResourceId: m.ResourceId, Key: m.Key, Value: 5}, writeIncrement)
+	require.NoError(t, w.shutdown(context.Background()))
+
+	status, _, body = doIfMatch(t, app, http.MethodPut, target, `"2"`, MetricUpdateDTO{Value: 30})
+	assert.Equal(t, http.StatusPreconditionFailed, status, string(body))
+
+	status, tag, body = doIfMatch(t, app, http.MethodGet, target, "", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Equal(t, `"3"`, tag)
+	assert.Contains(t, string(body), `"value":25`)
+
+	status, tag, body = doIfMatch(t, app, http.MethodPut, target, "*", MetricUpdateDTO{Value: 30})
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Equal(t, `"4"`, tag)
+	status, tag, body = doIfMatch(t, app, http.MethodPut, target, "", MetricUpdateDTO{Value: 40})
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Equal(t, `"5"`, tag, "If-Match is optional")
+
+	audit := getAudit(t, app, target+"/audit")
+	assert.Equal(t, 3, audit.TotalItems, "refused updates are not audited")
+}
+
+func TestExecAudited_Stale(t *testing.T) {
+	db := newTestDB(t)
+	m := seedMetric(t, db, "reputation", 10)
+	ctx := context.Background()
+
+	sqlStr, args, err := query.New(db.Dialect()).
+		Update(Metric{}.TableName()).
+		Set("value", 20).
+		Set("version", 3).
+		Where(query.Eq("id", m.Id)).
+		Where(query.Eq("version", 2)).
+		Build()
+	require.NoError(t, err)
+
+	after := 20
+	entry := AuditEntry{Id: uuid.New().String(), MetricId: m.Id, Operation: OperationUpdate, OldValue: 10, NewValue: &after, CreatedAt: &time.Time{}}
+	err = execAudited(ctx, db, entry, errPreconditionFailed, sqlStr, args)
+	assert.ErrorIs(t, err, errPreconditionFailed)
+	assert.Zero(t, countRows(t, db, "metric_audit"))
+}
+
+func TestMetricResource_UpdatedAt(t *testing.T) {
+	db := newTestDB(t)
+	older := seedMetric(t, db, "score", 10)
+	newer := seedMetric(t, db, "likes", 1)
+	ctx := context.Background()
+	past := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := db.Exec(ctx, rebind(db, "UPDATE metrics SET created_at = ?, updated_at = ?"), past, past)
+	require.NoError(t, err)
+
+	config := DefaultConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(ctx) })
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(app, db, &config, writer, nil, nil, nil, nil)
+
+	status, body := doJSON(t, app, http.MethodPut, "/metrics/"+older.Id, MetricUpdateDTO{Value: 20})
+	require.Equal(t, http.StatusOK, status, string(body))
+	var updated MetricResponseDTO
+	require.NoError(t, json.Unmarshal(body, &updated))
+	require.NotNil(t, updated.UpdatedAt)
+	assert.True(t, updated.UpdatedAt.After(past))
+	require.NotNil(t, updated.CreatedAt)
+	assert.True(t, updated.CreatedAt.Equal(past), "updates leave created_at alone")
+
+	var collection struct {
+		Member []MetricResponseDTO `json:"hydra:member"`
+	}
+	status, body = doJSON(t, app, http.MethodGet, "/metrics?order[updatedAt]=desc", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.NoError(t, json.Unmarshal(body, &collection))
+	require.Len(t, collection.Member, 2)
+	assert.Equal(t, older.Id, collection.Member[0].ID)
+	assert.Equal(t, newer.Id, collection.Member[1].ID)
+	require.NotNil(t, collection.Member[1].UpdatedAt)
+	assert.True(t, collection.Member[1].UpdatedAt.Equal(past))
+
+	status, body = doJSON(t, app, http.MethodGet, "/metrics?order[updatedAt]=asc", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.NoError(t, json.Unmarshal(body, &collection))
+	require.Len(t, collection.Member, 2)
+	assert.Equal(t, newer.Id, collection.Member[0].ID)
+}
@@ -5,6 +5,12 @@ import (
 	"github.com/nicolasbonnici/gorest/database"
 )
 
-func RegisterRoutes(router fiber.Router, db database.Database, config *Config, writer *batchWriter) {
-	RegisterMetricRoutes(router, db, config, writer)
+func RegisterRoutes(router fiber.Router, db database.Database, config *Config, writer *batchWriter, hub *metricHub, events *eventBus, dedup *dedupFilter, limiter *rateLimiter, quotas *tenantQuotas) {
+	// Static /metrics/* routes go first so /metrics/:id does not shadow them.
+	RegisterStreamRoutes(router, config, hub)
+	RegisterSocketRoutes(router, config, hub)
+	RegisterAggregateRoutes(router, db, config)
+	RegisterAlertRoutes(router, db, config)
+	RegisterUniqueRoutes(router, db, config)
+	RegisterMetricRoutes(router, db, config, writer, events, dedup, limiter, quotas)
 }
@@ -0,0 +1,261 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/logger"
+)
+
+const (
+	socketWriteTimeout = 10 * time.Second
+	socketPongTimeout  = 60 * time.Second
+	socketPingInterval = 30 * time.Second
+	socketMaxMessage   = 4096
+)
+
+// Client actions and server message types of the /metrics/ws protocol.
+const (
+	SocketActionSubscribe   = "subscribe"
+	SocketActionUnsubscribe = "unsubscribe"
+
+	SocketMessageSubscribed   = "subscribed"
+	SocketMessageUnsubscribed = "unsubscribed"
+	SocketMessageMetric       = "metric"
+	SocketMessageError        = "error"
+	SocketMessageOverflow     = "overflow"
+)
+
+// SocketRequest is a client frame on /metrics/ws.
+type SocketRequest struct {
+	Action     string `json:"action"`
+	Resource   string `json:"resource"`
+	ResourceId string `json:"resourceId"`
+	Key        string `json:"key"`
+}
+
+// SocketMessage is a server frame on /metrics/ws. Data is set on metric
+// messages, the tuple fields on (un)subscription acknowledgements.
+type SocketMessage struct {
+	Type       string             `json:"type"`
+	Resource   string             `json:"resource,omitempty"`
+	ResourceId string             `json:"resourceId,omitempty"`
+	Key        string             `json:"key,omitempty"`
+	Message    string             `json:"message,omitempty"`
+	Data       *MetricResponseDTO `json:"data,omitempty"`
+}
+
+type socketTopic struct {
+	resource   string
+	resourceID string
+	key        string
+}
+
+// socketTopics is the mutable set of tuples one connection is subscribed to.
+// hidden holds the private keys the connection may not subscribe to.
+type socketTopics struct {
+	mu     sync.RWMutex
+	topics map[socketTopic]struct{}
+	hidden map[string]bool
+}
+
+func (t *socketTopics) matches(m Metric) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.topics[socketTopic{resource: m.Resource, resourceID: m.ResourceId, key: m.Key}]
+	return ok
+}
+
+func (t *socketTopics) add(topic socketTopic) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.topics[topic]; !ok && len(t.topics) >= MaxFilterValuesPerField {
+		return false
+	}
+	t.topics[topic] = struct{}{}
+	return true
+}
+
+func (t *socketTopics) remove(topic socketTopic) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.topics, topic)
+}
+
+// SocketResource lets a client subscribe to and unsubscribe from individual
+// (resource, resourceId, key) tuples over a single WebSocket connection, and
+// pushes each persisted metric matching one of them.
+type SocketResource struct {
+	hub       *metricHub
+	hooks     *MetricHooks
+	converter *MetricConverter
+	upgrader  websocket.FastHTTPUpgrader
+}
+
+func RegisterSocketRoutes(router fiber.Router, config *Config, hub *metricHub) {
+	res := &SocketResource{
+		hub:       hub,
+		hooks:     NewMetricHooks(config),
+		converter: &MetricConverter{},
+		upgrader: websocket.FastHTTPUpgrader{
+			HandshakeTimeout: socketWriteTimeout,
+		},
+	}
+
+	router.Get("/metrics/ws", res.Connect)
+}
+
+// Connect upgrades GET /metrics/ws to a WebSocket connection.
+func (r *SocketResource) Connect(c fiber.Ctx) error {
+	if !websocket.FastHTTPIsWebSocketUpgrade(c.RequestCtx()) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	// Topics are subscribed to after the upgrade, without a Fiber context, so
+	// the caller is authorized once for reads of every metric, and its tenant
+	// and the private keys it may not read are resolved up front.
+	if err := r.hooks.config.authorize(c, AuthorizationRequest{Operation: OperationRead}); err != nil {
+		return err
+	}
+	tenant, err := r.hooks.config.tenant(c)
+	if err != nil {
+		return err
+	}
+
+	hidden := make(map[string]bool)
+	for _, key := range r.hooks.config.hiddenKeys(c) {
+		hidden[key] = true
+	}
+
+	err = r.upgrader.Upgrade(c.RequestCtx(), func(conn *websocket.Conn) {
+		r.serve(conn, tenant, hidden)
+	})
+	if err != nil {
+		return fiber.ErrUpgradeRequired
+	}
+	return nil
+}
+
+func (r *SocketResource) serve(conn *websocket.Conn, tenant string, hidden map[string]bool) {
+	defer conn.Close()
+
+	topics := &socketTopics{topics: make(map[socketTopic]struct{}), hidden: hidden}
+	sub := r.hub.subscribe(tenantMatcher{matcher: topics, tenant: tenant})
+	defer r.hub.unsubscribe(sub)
+
+	// The connection allows one concurrent reader and one writer: the reader
+	// goroutine hands its replies to this goroutine, which owns every write.
+	replies := make(chan SocketMessage, 16)
+	readerDone := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.read(conn, topics, replies, readerDone, stop)
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case m := <-sub.events:
+			dto := r.converter.ModelToResponseDTO(m)
+			err = r.write(conn, SocketMessage{Type: SocketMessageMetric, Data: &dto})
+		case reply := <-replies:
+			err = r.write(conn, reply)
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		case <-sub.done:
+			if sub.overflow {
+				_ = r.write(conn, SocketMessage{Type: SocketMessageOverflow, Message: "subscriber too slow, reconnect to resume"})
+			}
+			return
+		case <-readerDone:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *SocketResource) read(conn *websocket.Conn, topics *socketTopics, replies chan<- SocketMessage, done, stop chan struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(socketMaxMessage)
+	_ = conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Log.Debug("metrics: socket closed", "error", err)
+			}
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+
+		var reply SocketMessage
+		var req SocketRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			reply = SocketMessage{Type: SocketMessageError, Message: "invalid message"}
+		} else {
+			reply = r.handle(topics, req)
+		}
+
+		select {
+		case replies <- reply:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *SocketResource) handle(topics *socketTopics, req SocketRequest) SocketMessage {
+	key, err := r.hooks.validateTarget(req.Resource, req.ResourceId, req.Key)
+	if err != nil {
+		return SocketMessage{Type: SocketMessageError, Message: errorMessage(err)}
+	}
+
+	if topics.hidden[key] {
+		return SocketMessage{Type: SocketMessageError, Message: "key is private"}
+	}
+
+	topic := socketTopic{resource: req.Resource, resourceID: req.ResourceId, key: key}
+	reply := SocketMessage{Resource: req.Resource, ResourceId: req.ResourceId, Key: key}
+
+	switch req.Action {
+	case SocketActionSubscribe:
+		if !topics.add(topic) {
+			msg := fmt.Sprintf("cannot subscribe to more than %d metrics per connection", MaxFilterValuesPerField)
+			return SocketMessage{Type: SocketMessageError, Message: msg}
+		}
+		reply.Type = SocketMessageSubscribed
+	case SocketActionUnsubscribe:
+		topics.remove(topic)
+		reply.Type = SocketMessageUnsubscribed
+	default:
+		return SocketMessage{Type: SocketMessageError, Message: "action must be subscribe or unsubscribe"}
+	}
+
+	return reply
+}
+
+func (r *SocketResource) write(conn *websocket.Conn, msg SocketMessage) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return conn.WriteJSON(msg)
+}
+
+func errorMessage(err error) string {
+	if ferr, ok := err.(*fiber.Error); ok {
+		return ferr.Message
+	}
+	return err.Error()
+}
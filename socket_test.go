@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSocketTestServer(t *testing.T, hub *metricHub) string {
+	t.Helper()
+
+	config := DefaultConfig()
+	app := fiber.New()
+	RegisterSocketRoutes(app, &config, hub)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln, fiber.ListenConfig{DisableStartupMessage: true})
+	}()
+	t.Cleanup(func() {
+		hub.close()
+		_ = app.Shutdown()
+	})
+
+	return "ws://" + ln.Addr().String() + "/metrics/ws"
+}
+
+func dialSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func readSocketMessage(t *testing.T, conn *websocket.Conn) SocketMessage {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	var msg SocketMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestSocketResource_SubscribeAndUnsubscribe(t *testing.T) {
+	hub := newMetricHub(8)
+	conn := dialSocket(t, newSocketTestServer(t, hub))
+
+	views := sampleMetric()
+	views.Key = "views"
+	likes := views
+	likes.Id = sampleMetric().Id
+	likes.Key = "likes"
+
+	sub := SocketRequest{Action: SocketActionSubscribe, Resource: "post", ResourceId: views.ResourceId, Key: " views "}
+	require.NoError(t, conn.WriteJSON(sub))
+
+	ack := readSocketMessage(t, conn)
+	assert.Equal(t, SocketMessage{Type: SocketMessageSubscribed, Resource: "post", ResourceId: views.ResourceId, Key: "views"}, ack)
+
+	hub.publish([]Metric{likes, views})
+
+	msg := readSocketMessage(t, conn)
+	assert.Equal(t, SocketMessageMetric, msg.Type)
+	require.NotNil(t, msg.Data)
+	assert.Equal(t, views.Id, msg.Data.ID)
+
+	sub.Action = SocketActionUnsubscribe
+	require.NoError(t, conn.WriteJSON(sub))
+	assert.Equal(t, SocketMessageUnsubscribed, readSocketMessage(t, conn).Type)
+
+	// Nothing is delivered once unsubscribed; the next frame is the reply to
+	// the invalid request below.
+	hub.publish([]Metric{views})
+	require.NoError(t, conn.WriteJSON(SocketRequest{Action: "watch", Resource: "post", ResourceId: views.ResourceId, Key: "views"}))
+	assert.Equal(t, SocketMessage{Type: SocketMessageError, Message: "action must be subscribe or unsubscribe"}, readSocketMessage(t, conn))
+}
+
+func TestSocketResource_RejectsInvalidSubscriptions(t *testing.T) {
+	conn := dialSocket(t, newSocketTestServer(t, newMetricHub(0)))
+
+	tests := []struct {
+		name    string
+		request SocketRequest
+		want    string
+	}{
+		{
+			name:    "disallowed resource",
+			request: SocketRequest{Action: SocketActionSubscribe, Resource: "comment", ResourceId: sampleMetric().ResourceId, Key: "views"},
+			want:    "resource type is not allowed",
+		},
+		{
+			name:    "invalid resource id",
+			request: SocketRequest{Action: SocketActionSubscribe, Resource: "post", ResourceId: "42", Key: "views"},
+			want:    "resourceId must be a valid UUID",
+		},
+		{
+			name:    "empty key",
+			request: SocketRequest{Action: SocketActionSubscribe, Resource: "post", ResourceId: sampleMetric().ResourceId, Key: "  "},
+			want:    "key cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, conn.WriteJSON(tt.request))
+			msg := readSocketMessage(t, conn)
+			assert.Equal(t, SocketMessageError, msg.Type)
+			assert.Equal(t, tt.want, msg.Message)
+		})
+	}
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{")))
+	assert.Equal(t, "invalid message", readSocketMessage(t, conn).Message)
+}
+
+func TestSocketResource_RequiresUpgrade(t *testing.T) {
+	config := DefaultConfig()
+	app := fiber.New()
+	RegisterSocketRoutes(app, &config, newMetricHub(0))
+
+	status, _ := doGet(t, app, "/metrics/ws")
+	assert.Equal(t, http.StatusUpgradeRequired, status)
+}
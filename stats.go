@@ -0,0 +1,40 @@
+package metrics
+
+import "sync/atomic"
+
+// Stats counts what the plugin did since it was created.
+type Stats struct {
+	// RateLimited counts the creates rejected with 429, by the scope whose
+	// limit they exceeded (ip, user or metric).
+	RateLimited map[string]uint64 `json:"rateLimited"`
+}
+
+// pluginStats holds the live counters behind Stats. Its zero value is ready to
+// use.
+type pluginStats struct {
+	rateLimitedByIP     atomic.Uint64
+	rateLimitedByUser   atomic.Uint64
+	rateLimitedByMetric atomic.Uint64
+}
+
+// Stats returns a snapshot of the plugin's counters.
+func (p *MetricsPlugin) Stats() Stats {
+	return Stats{
+		RateLimited: map[string]uint64{
+			RateLimitByIP:     p.stats.rateLimitedByIP.Load(),
+			RateLimitByUser:   p.stats.rateLimitedByUser.Load(),
+			RateLimitByMetric: p.stats.rateLimitedByMetric.Load(),
+		},
+	}
+}
+
+func (s *pluginStats) rateLimited(scope string) {
+	switch scope {
+	case RateLimitByIP:
+		s.rateLimitedByIP.Add(1)
+	case RateLimitByUser:
+		s.rateLimitedByUser.Add(1)
+	case RateLimitByMetric:
+		s.rateLimitedByMetric.Add(1)
+	}
+}
@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/sse"
+	"github.com/nicolasbonnici/gorest/processor"
+)
+
+const defaultStreamBufferSize = 64
+
+// metricMatcher selects which flushed metrics a subscription receives.
+type metricMatcher interface {
+	matches(m Metric) bool
+}
+
+// metricFilter matches metrics against exact values per field. A field absent
+// from the filter matches everything.
+type metricFilter map[string]map[string]bool
+
+func (f metricFilter) matches(m Metric) bool {
+	for field, values := range f {
+		var got string
+		switch field {
+		case "id":
+			got = m.Id
+		case "resource":
+			got = m.Resource
+		case "resourceId":
+			got = m.ResourceId
+		case "name":
+			got = m.Key
+		}
+		if !values[got] {
+			return false
+		}
+	}
+	return true
+}
+
+// single returns the value field is filtered on, or "" unless there is
+// exactly one.
+func (f metricFilter) single(field string) string {
+	if len(f[field]) != 1 {
+		return ""
+	}
+	for value := range f[field] {
+		return value
+	}
+	return ""
+}
+
+// streamFilterFields are the AllowedFields a stream can be filtered on; key is
+// accepted as an alias of name, matching the DTO field.
+var streamFilterFields = map[string]string{
+	"id":         "id",
+	"resource":   "resource",
+	"resourceId": "resourceId",
+	"name":       "name",
+	"key":        "name",
+}
+
+// subscription is one live consumer of flushed metrics. done is closed when
+// the subscription ends, either because the hub shut down or because the
+// consumer fell behind and its buffer overflowed.
+type subscription struct {
+	events   chan Metric
+	done     chan struct{}
+	filter   metricMatcher
+	overflow bool
+	once     sync.Once
+}
+
+func (s *subscription) end() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// metricHub fans persisted metrics out to live subscribers. Publishing never
+// blocks the writer: a subscriber whose bounded buffer is full is dropped.
+type metricHub struct {
+	bufferSize int
+
+	mu     sync.Mutex
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+func newMetricHub(bufferSize int) *metricHub {
+	if bufferSize <= 0 {
+		bufferSize = defaultStreamBufferSize
+	}
+	return &metricHub{
+		bufferSize: bufferSize,
+		subs:       make(map[*subscription]struct{}),
+	}
+}
+
+func (h *metricHub) subscribe(filter metricMatcher) *subscription {
+	sub := &subscription{
+		events: make(chan Metric, h.bufferSize),
+		done:   make(chan struct{}),
+		filter: filter,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		sub.end()
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+func (h *metricHub) unsubscribe(sub *subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+	sub.end()
+}
+
+// publish is a batchWriter flush listener.
+func (h *metricHub) publish(batch []Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		for _, m := range batch {
+			if !sub.filter.matches(m) {
+				continue
+			}
+			select {
+			case sub.events <- m:
+			default:
+				sub.overflow = true
+				delete(h.subs, sub)
+				sub.end()
+			}
+			if sub.overflow {
+				break
+			}
+		}
+	}
+}
+
+func (h *metricHub) size() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// close ends every subscription; used on plugin shutdown.
+func (h *metricHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		sub.end()
+	}
+}
+
+// StreamResource pushes metrics to clients as Server-Sent Events as soon as the
+// batch writer has persisted them.
+type StreamResource struct {
+	hub          *metricHub
+	config       *Config
+	converter    *MetricConverter
+	errorHandler processor.ErrorHandler
+}
+
+func RegisterStreamRoutes(router fiber.Router, config *Config, hub *metricHub) {
+	res := &StreamResource{
+		hub:          hub,
+		config:       config,
+		converter:    &MetricConverter{},
+		errorHandler: &processor.DefaultErrorHandler{},
+	}
+
+	router.Get("/metrics/stream", res.Stream)
+}
+
+// Stream answers GET /metrics/stream?resource=post&key=views. Each persisted
+// metric matching the filters is sent as a "metric" event; a client that falls
+// behind receives a final "overflow" event and is disconnected so it can
+// reconnect and resync.
+func (r *StreamResource) Stream(c fiber.Ctx) error {
+	filter, err := r.parseFilter(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "validate")
+	}
+
+	err = r.config.authorize(c, AuthorizationRequest{
+		Operation:    OperationRead,
+		ResourceType: filter.single("resource"),
+		ResourceID:   filter.single("resourceId"),
+		Key:          filter.single("name"),
+	})
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	tenant, err := r.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	sub := r.hub.subscribe(tenantMatcher{
+		matcher: hideKeys(filter, r.config.hiddenKeys(c)),
+		tenant:  tenant,
+	})
+
+	return sse.New(sse.Config{
+		Handler: func(_ fiber.Ctx, stream *sse.Stream) error {
+			for {
+				select {
+				case m := <-sub.events:
+					if err := stream.Event(sse.Event{
+						ID:   m.Id,
+						Name: "metric",
+						Data: r.converter.ModelToResponseDTO(m),
+					}); err != nil {
+						return err
+					}
+				case <-sub.done:
+					if sub.overflow {
+						return stream.Event(sse.Event{Name: "overflow", Data: "subscriber too slow, reconnect to resume"})
+					}
+					return nil
+				case <-stream.Done():
+					return stream.Err()
+				}
+			}
+		},
+		OnClose: func(fiber.Ctx, error) {
+			r.hub.unsubscribe(sub)
+		},
+	})(c)
+}
+
+func (r *StreamResource) parseFilter(c fiber.Ctx) (metricFilter, error) {
+	filter := metricFilter{}
+
+	for k, v := range c.Request().URI().QueryArgs().All() {
+		field, ok := streamFilterFields[string(k)]
+		if !ok {
+			return nil, fiber.NewError(400, fmt.Sprintf("unsupported stream filter: %s", k))
+		}
+
+		parsed := splitList(string(v))
+		if len(parsed) == 0 {
+			continue
+		}
+
+		values := filter[field]
+		if values == nil {
+			values = map[string]bool{}
+			filter[field] = values
+		}
+		for _, value := range parsed {
+			values[value] = true
+		}
+		if len(values) > MaxFilterValuesPerField {
+			return nil, fiber.NewError(400, fmt.Sprintf("too many values for filter %s", k))
+		}
+	}
+
+	for resourceType := range filter["resource"] {
+		if !r.config.IsAllowedType(resourceType) {
+			return nil, fiber.NewError(400, "resource type is not allowed")
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricHub_PublishesMatchingMetrics(t *testing.T) {
+	hub := newMetricHub(8)
+	sub := hub.subscribe(metricFilter{"name": {"views": true}})
+
+	views := sampleMetric()
+	views.Key = "views"
+	likes := sampleMetric()
+	likes.Key = "likes"
+
+	hub.publish([]Metric{likes, views})
+
+	select {
+	case got := <-sub.events:
+		assert.Equal(t, views.Id, got.Id)
+	default:
+		t.Fatal("expected the matching metric to be delivered")
+	}
+	assert.Len(t, sub.events, 0)
+}
+
+func TestMetricHub_DropsSlowSubscriber(t *testing.T) {
+	hub := newMetricHub(2)
+	slow := hub.subscribe(metricFilter{})
+	fast := hub.subscribe(metricFilter{})
+
+	hub.publish([]Metric{sampleMetric(), sampleMetric()})
+	<-fast.events
+	<-fast.events
+
+	hub.publish([]Metric{sampleMetric()})
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("slow subscriber should have been dropped")
+	}
+	assert.True(t, slow.overflow)
+	assert.Equal(t, 1, hub.size())
+	assert.Len(t, fast.events, 1)
+}
+
+func TestMetricHub_CloseEndsSubscriptions(t *testing.T) {
+	hub := newMetricHub(0)
+	sub := hub.subscribe(metricFilter{})
+
+	hub.close()
+
+	<-sub.done
+	assert.False(t, sub.overflow)
+	<-hub.subscribe(metricFilter{}).done
+}
+
+func TestBatchWriter_NotifiesFlushListeners(t *testing.T) {
+	db := newTestDB(t)
+	flushed := make(chan []Metric, 1)
+	w := newBatchWriter(db, batchWriterOptions{
+		flushInterval: time.Hour,
+		flushListeners: []func([]Metric){func(batch []Metric) {
+			flushed <- append([]Metric(nil), batch...)
+		}},
+	})
+
+	good := sampleMetric()
+	clash := good
+	clash.Id = sampleMetric().Id
+	w.enqueue(good)
+	w.enqueue(clash)
+
+	require.NoError(t, w.shutdown(context.Background()))
+
+	got := <-flushed
+	require.Len(t, got, 1, "only persisted metrics are announced")
+	assert.Equal(t, good.Id, got[0].Id)
+}
+
+func TestStreamResource_StreamsFlushedMetrics(t *testing.T) {
+	config := DefaultConfig()
+	hub := newMetricHub(8)
+
+	app := fiber.New()
+	RegisterStreamRoutes(app, &config, hub)
+
+	views := sampleMetric()
+	views.Key = "views"
+	likes := sampleMetric()
+	likes.Key = "likes"
+
+	go func() {
+		for hub.size() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		hub.publish([]Metric{likes, views})
+		hub.close()
+	}()
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics/stream?resource=post&key=views", nil), fiber.TestConfig{
+		Timeout:       2 * time.Second,
+		FailOnTimeout: false,
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fiber.MIMETextEventStream, resp.Header.Get(fiber.HeaderContentType))
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "id: "+views.Id+"\nevent: metric\n")
+	assert.NotContains(t, string(body), likes.Id)
+}
+
+func TestStreamResource_RejectsInvalidFilters(t *testing.T) {
+	config := DefaultConfig()
+	app := fiber.New()
+	RegisterStreamRoutes(app, &config, newMetricHub(0))
+
+	for _, target := range []string{
+		"/metrics/stream?value=3",
+		"/metrics/stream?resource=comment",
+	} {
+		status, _ := doGet(t, app, target)
+		assert.Equal(t, http.StatusBadRequest, status, target)
+	}
+}
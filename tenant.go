@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// MaxTenantLength matches the width of the tenant column.
+const MaxTenantLength = 255
+
+// TenantExtractor resolves the tenant a request acts for. A returned
+// *fiber.Error is answered with its status; any other error with 403. The
+// empty tenant is the default one, which every metric recorded before
+// multi-tenancy belongs to.
+type TenantExtractor func(c fiber.Ctx) (string, error)
+
+// TenantFromHeader reads the tenant from the named request header and refuses
+// requests without it.
+func TenantFromHeader(header string) TenantExtractor {
+	return func(c fiber.Ctx) (string, error) {
+		tenant := strings.TrimSpace(c.Get(header))
+		if tenant == "" {
+			return "", fiber.NewError(fiber.StatusBadRequest, header+" header is required")
+		}
+		return tenant, nil
+	}
+}
+
+type tenantKey struct{}
+
+// WithTenant records tenant on ctx, where Embedder.Load and CascadeHooks pick
+// it up.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant recorded by WithTenant, or the default
+// tenant.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := tenantOnContext(ctx)
+	return tenant
+}
+
+// tenantOnContext returns the tenant recorded by WithTenant and whether there
+// is one.
+func tenantOnContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// TenantMiddleware records the tenant of every request on its context with
+// WithTenant, for the hooks of other resources (CascadeHooks) that only see a
+// context.Context. Requests whose tenant cannot be resolved are refused.
+func (p *MetricsPlugin) TenantMiddleware() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		tenant, err := p.config.tenant(c)
+		if err != nil {
+			return err
+		}
+		c.SetContext(WithTenant(c.Context(), tenant))
+		return c.Next()
+	}
+}
+
+// tenantExtractor returns TenantExtractor, falling back to TenantFromHeader
+// when only tenant_header is configured. It is nil in single-tenant setups.
+func (c *Config) tenantExtractor() TenantExtractor {
+	if c.TenantExtractor != nil {
+		return c.TenantExtractor
+	}
+	if c.TenantHeader != "" {
+		return TenantFromHeader(c.TenantHeader)
+	}
+	return nil
+}
+
+// tenant resolves the tenant of ctx; always the default tenant when no
+// extractor is configured. Extractors usually return strings pointing into the
+// request buffer, which fasthttp reuses once the handler returns, while the
+// tenant outlives the request in queued writes, events and quota buckets; it
+// is therefore returned as a copy.
+func (c *Config) tenant(ctx fiber.Ctx) (string, error) {
+	extract := c.tenantExtractor()
+	if extract == nil {
+		return "", nil
+	}
+
+	tenant, err := extract(ctx)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return "", fiberErr
+		}
+		return "", fiber.NewError(fiber.StatusForbidden, err.Error())
+	}
+	if err := validateTenant(tenant); err != nil {
+		return "", err
+	}
+	return strings.Clone(tenant), nil
+}
+
+func validateTenant(tenant string) error {
+	if len(tenant) > MaxTenantLength {
+		return fiber.NewError(fiber.StatusBadRequest, "tenant exceeds maximum length")
+	}
+	return nil
+}
+
+// tenantMatcher narrows matcher to the metrics of one tenant, so live streams
+// never cross tenants.
+type tenantMatcher struct {
+	matcher metricMatcher
+	tenant  string
+}
+
+func (m tenantMatcher) matches(metric Metric) bool {
+	return metric.Tenant == m.tenant && m.matcher.matches(metric)
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTenantHeader = "X-Tenant"
+
+// asTenant sends every request on behalf of tenant.
+func asTenant(tenant string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		c.Request().Header.Set(testTenantHeader, tenant)
+		return c.Next()
+	}
+}
+
+func TestTenantFromHeader(t *testing.T) {
+	newApp := func(middleware ...any) *fiber.App {
+		app := fiber.New()
+		for _, m := range middleware {
+			app.Use(m)
+		}
+		app.Get("/", func(c fiber.Ctx) error {
+			tenant, err := TenantFromHeader(testTenantHeader)(c)
+			if err != nil {
+				return err
+			}
+			return c.SendString(tenant)
+		})
+		return app
+	}
+
+	status, body := doJSON(t, newApp(), http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, string(body), "X-Tenant header is required")
+
+	status, body = doJSON(t, newApp(asTenant("acme")), http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "acme", string(body))
+}
+
+func TestConfig_TenantOutlivesRequestBuffer(t *testing.T) {
+	config := DefaultConfig()
+	config.TenantHeader = testTenantHeader
+
+	app := fiber.New()
+	app.Use(asTenant("acme"))
+	app.Get("/", func(c fiber.Ctx) error {
+		tenant, err := config.tenant(c)
+		if err != nil {
+			return err
+		}
+		// fasthttp overwrites header values in place, as it does when the
+		// request buffer is reused for the next request.
+		c.Request().Header.Set(testTenantHeader, "zzzz")
+		return c.SendString(tenant)
+	})
+
+	status, body := doJSON(t, app, http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "acme", string(body))
+}
+
+func TestMetricsPlugin_TenantMiddleware(t *testing.T) {
+	config := DefaultConfig()
+	config.TenantHeader = testTenantHeader
+	p := &MetricsPlugin{config: config}
+
+	newApp := func(middleware ...any) *fiber.App {
+		app := fiber.New()
+		for _, m := range middleware {
+			app.Use(m)
+		}
+		app.Use(p.TenantMiddleware())
+		app.Get("/", func(c fiber.Ctx) error {
+			return c.SendString(TenantFromContext(c.Context()))
+		})
+		return app
+	}
+
+	status, _ := doJSON(t, newApp(), http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body := doJSON(t, newApp(asTenant("acme")), http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "acme", string(body))
+}
+
+func TestConfig_Tenant(t *testing.T) {
+	tests := []struct {
+		name       string
+		extractor  TenantExtractor
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "no extractor is the default tenant",
+			wantStatus: http.StatusOK,
+			want:       "",
+		},
+		{
+			name:       "extracted tenant",
+			extractor:  func(fiber.Ctx) (string, error) { return "acme", nil },
+			wantStatus: http.StatusOK,
+			want:       "acme",
+		},
+		{
+			name:       "fiber error keeps its status",
+			extractor:  func(fiber.Ctx) (string, error) { return "", fiber.ErrUnauthorized },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "other errors are forbidden",
+			extractor:  func(fiber.Ctx) (string, error) { return "", errors.New("unknown tenant") },
+			wantStatus: http.StatusForbidden,
+			want:       "unknown tenant",
+		},
+		{
+			name:       "tenant too long",
+			extractor:  func(fiber.Ctx) (string, error) { return strings.Repeat("a", MaxTenantLength+1), nil },
+			wantStatus: http.StatusBadRequest,
+			want:       "tenant exceeds maximum length",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.TenantExtractor = tt.extractor
+
+			app := fiber.New()
+			app.Get("/", func(c fiber.Ctx) error {
+				tenant, err := config.tenant(c)
+				if err != nil {
+					return err
+				}
+				return c.SendString(tenant)
+			})
+
+			status, body := doJSON(t, app, http.MethodGet, "/", nil)
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.want != "" {
+				assert.Contains(t, string(body), tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorder_WithTenant(t *testing.T) {
+	rec, w := newTestRecorder(t, DefaultConfig())
+	ctx := context.Background()
+	resourceID := uuid.New().String()
+
+	require.NoError(t, rec.WithTenant("acme").Increment("post", resourceID, "views", 3))
+	require.NoError(t, rec.WithTenant("globex").Increment("post", resourceID, "views", 5))
+	require.NoError(t, rec.Increment("post", resourceID, "views", 1))
+	assert.Error(t, rec.WithTenant(strings.Repeat("a", MaxTenantLength+1)).Increment("post", resourceID, "views", 1))
+	require.NoError(t, w.shutdown(ctx))
+
+	for tenant, want := range map[string]int{"acme": 3, "globex": 5, "": 1} {
+		got, err := rec.WithTenant(tenant).Get(ctx, "post", resourceID, "views")
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "tenant %q", tenant)
+	}
+}
+
+func TestMetricResource_TenantIsolation(t *testing.T) {
+	rec, seed := newTestRecorder(t, DefaultConfig())
+	db := rec.db
+	ctx := context.Background()
+	resourceID := uuid.New().String()
+	require.NoError(t, rec.WithTenant("acme").Set("post", resourceID, "views", 3))
+	require.NoError(t, rec.WithTenant("globex").Set("post", resourceID, "views", 5))
+	require.NoError(t, seed.shutdown(ctx))
+
+	globexViews, err := fetchMetric(ctx, db, Metric{Tenant: "globex", Resource: "post", ResourceId: resourceID, Key: "views"})
+	require.NoError(t, err)
+	require.NotNil(t, globexViews)
+
+	config := DefaultConfig()
+	config.TenantHeader = testTenantHeader
+	require.NoError(t, config.Validate())
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+
+	newApp := func(tenant string) *fiber.App {
+		app := fiber.New()
+		app.Use(withRoles(defaultAdminRole))
+		if tenant != "" {
+			app.Use(asTenant(tenant))
+		}
+		RegisterRoutes(app, db, &config, writer, newMetricHub(0), nil, nil, nil, nil)
+		return app
+	}
+	acme, globex, missing := newApp("acme"), newApp("globex"), newApp("")
+
+	status, body := doJSON(t, missing, http.MethodGet, "/metrics", nil)
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+
+	status, body = doJSON(t, acme, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), `"tenant":"acme"`)
+	assert.NotContains(t, string(body), "globex")
+
+	status, body = doJSON(t, acme, http.MethodGet, "/metrics/resources/post/"+resourceID, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var values map[string]int
+	require.NoError(t, json.Unmarshal(body, &values))
+	assert.Equal(t, map[string]int{"views": 3}, values)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		status, body = doJSON(t, acme, method, "/metrics/"+globexViews.Id, MetricUpdateDTO{Value: 0})
+		assert.Equal(t, http.StatusNotFound, status, "%s %s", method, string(body))
+	}
+
+	status, body = doJSON(t, globex, http.MethodPut, "/metrics/"+globexViews.Id, MetricUpdateDTO{Value: 7})
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	status, body = doJSON(t, acme, http.MethodPost, "/metrics", MetricCreateDTO{
+		Resource: "post", ResourceId: resourceID, Key: "likes", Value: 1,
+	})
+	assert.Equal(t, http.StatusCreated, status, string(body))
+	require.NoError(t, writer.shutdown(ctx))
+
+	likes, err := fetchMetric(ctx, db, Metric{Tenant: "acme", Resource: "post", ResourceId: resourceID, Key: "likes"})
+	require.NoError(t, err)
+	assert.NotNil(t, likes, "creates belong to the caller's tenant")
+
+	stored, err := fetchMetric(ctx, db, Metric{Tenant: "globex", Resource: "post", ResourceId: resourceID, Key: "views"})
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, 7, stored.Value)
+}
+
+func TestTenantMatcher(t *testing.T) {
+	matcher := tenantMatcher{matcher: metricFilter{}, tenant: "acme"}
+	assert.True(t, matcher.matches(Metric{Tenant: "acme", Resource: "post", Key: "views"}))
+	assert.False(t, matcher.matches(Metric{Tenant: "globex", Resource: "post", Key: "views"}))
+	assert.False(t, matcher.matches(Metric{Resource: "post", Key: "views"}))
+}
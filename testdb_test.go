@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nicolasbonnici/gorest-metrics/migrations"
+	"github.com/nicolasbonnici/gorest/database"
+	_ "github.com/nicolasbonnici/gorest/database/mysql"
+	_ "github.com/nicolasbonnici/gorest/database/postgres"
+	_ "github.com/nicolasbonnici/gorest/database/sqlite"
+)
+
+// The suite runs against a throwaway SQLite file by default. Setting
+// METRICS_TEST_DRIVER to postgres or mysql, and METRICS_TEST_DSN to a scratch
+// database of that driver, runs it there instead; the plugin's tables in that
+// database are dropped before every test.
+const (
+	testDriverEnv = "METRICS_TEST_DRIVER"
+	testDSNEnv    = "METRICS_TEST_DSN"
+)
+
+// testTables lists the tables the migrations and the tests create, in an
+// order they can be dropped in.
+var testTables = []string{
+	"posts", "metric_audit", "metric_dedup", "metric_sketches", "metric_milestones",
+	"metric_alerts", "metrics_archive", "metrics", "schema_migrations",
+}
+
+// newTestDB opens the test database and applies the plugin's migrations, so
+// tests run against the schema users get.
+func newTestDB(t *testing.T) database.Database {
+	t.Helper()
+
+	driver := os.Getenv(testDriverEnv)
+	if driver == "" {
+		driver = "sqlite"
+	}
+
+	var db database.Database
+	var err error
+	if driver == "sqlite" {
+		// busy_timeout + WAL let the pooled writer/reader connections coexist
+		// on a file-backed SQLite DB without spurious SQLITE_BUSY during the
+		// test.
+		path := filepath.Join(t.TempDir(), "metrics_test.db")
+		db, err = database.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	} else {
+		dsn := os.Getenv(testDSNEnv)
+		if dsn == "" {
+			t.Fatalf("%s=%s requires %s", testDriverEnv, driver, testDSNEnv)
+		}
+		db, err = database.Open(driver, dsn)
+	}
+	if err != nil {
+		t.Fatalf("open %s: %v", driver, err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	ctx := context.Background()
+	if driver != "sqlite" {
+		for _, table := range testTables {
+			if _, err := db.Exec(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+				t.Fatalf("drop %s: %v", table, err)
+			}
+		}
+	}
+
+	all, err := migrations.GetMigrations().Migrations()
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	for _, m := range all {
+		if err := m.ExecuteUp(ctx, db); err != nil {
+			t.Fatalf("migration %s: %v", m.FullName(), err)
+		}
+	}
+
+	return db
+}
+
+// rebind rewrites the ? placeholders of sqlStr for db's dialect, so raw SQL
+// in tests runs on every driver.
+func rebind(db database.Database, sqlStr string) string {
+	parts := strings.Split(sqlStr, "?")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i, part := range parts[1:] {
+		b.WriteString(db.Dialect().Placeholder(i + 1))
+		b.WriteString(part)
+	}
+	return b.String()
+}
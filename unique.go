@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nicolasbonnici/gorest/auth"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/logger"
+	"github.com/nicolasbonnici/gorest/processor"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+const (
+	sketchesTableName = "metric_sketches"
+	// uniqueBucketAll is the bucket of the all-time sketch. Day buckets are
+	// named YYYY-MM-DD, which sort chronologically and never collide with it.
+	uniqueBucketAll  = "all"
+	uniqueBucketDate = time.DateOnly
+)
+
+// writeUniques folds the visitors of each unique metric into its day and
+// all-time sketches and stores the all-time estimate as the metric's value.
+func (w *batchWriter) writeUniques(ctx context.Context, writes []pendingWrite) []Metric {
+	type tuple struct{ tenant, resource, resourceID, key string }
+
+	index := make(map[tuple]int, len(writes))
+	var metrics []Metric
+	var buckets []map[string][]uint64
+	for _, p := range writes {
+		t := tuple{p.metric.Tenant, p.metric.Resource, p.metric.ResourceId, p.metric.Key}
+		i, ok := index[t]
+		if !ok {
+			i = len(metrics)
+			index[t] = i
+			metrics = append(metrics, p.metric)
+			buckets = append(buckets, map[string][]uint64{})
+		}
+
+		at := time.Now()
+		if p.metric.CreatedAt != nil {
+			at = *p.metric.CreatedAt
+		}
+		day := at.UTC().Format(uniqueBucketDate)
+		buckets[i][day] = append(buckets[i][day], p.visitor)
+		buckets[i][uniqueBucketAll] = append(buckets[i][uniqueBucketAll], p.visitor)
+	}
+
+	persisted := make([]Metric, 0, len(metrics))
+	for i, m := range metrics {
+		count, err := addVisitors(ctx, w.db, m, buckets[i])
+		if err == nil {
+			m.Value = int(count)
+			m, err = w.execUpsert(ctx, pendingWrite{metric: m, mode: writeSet})
+		}
+		if err != nil {
+			logger.Log.Error("metrics: failed to persist metric",
+				"error", err,
+				"resource", metrics[i].Resource,
+				"key", metrics[i].Key,
+			)
+			continue
+		}
+		persisted = append(persisted, m)
+	}
+	return persisted
+}
+
+// addVisitors adds the hashed visitors of each bucket to the stored sketches
+// in one transaction and returns the all-time estimate. Sketch rows are
+// locked so concurrent instances merge rather than overwrite each other.
+func addVisitors(ctx context.Context, db database.Database, m Metric, buckets map[string][]uint64) (count uint64, err error) {
+	// A stable lock order keeps two instances from deadlocking.
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	for _, name := range names {
+		sketch, err := lockSketch(ctx, db, tx, m, name)
+		if err != nil {
+			return 0, err
+		}
+		for _, hash := range buckets[name] {
+			sketch.add(hash)
+		}
+		if err := saveSketch(ctx, db, tx, m, name, sketch); err != nil {
+			return 0, err
+		}
+		if name == uniqueBucketAll {
+			count = sketch.estimate()
+		}
+	}
+
+	return count, tx.Commit(ctx)
+}
+
+// lockSketch creates the bucket's sketch if needed and loads it, locking the
+// row until the transaction ends (SQLite transactions already serialise).
+func lockSketch(ctx context.Context, db database.Database, tx database.Tx, m Metric, bucket string) (*hyperLogLog, error) {
+	empty, _ := new(hyperLogLog).MarshalBinary()
+	insertSQL, args, err := query.New(db.Dialect()).
+		Insert(sketchesTableName).
+		Columns("tenant", "resource", "resource_id", "name", "bucket", "sketch").
+		Values(m.Tenant, m.Resource, m.ResourceId, m.Key, bucket, empty).
+		OnConflictDoNothing("tenant", "resource", "resource_id", "name", "bucket").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, insertSQL, args...); err != nil {
+		return nil, err
+	}
+
+	selectSQL, args, err := query.New(db.Dialect()).
+		Select("sketch").
+		From(sketchesTableName).
+		Where(metricCondition(m)).
+		Where(query.Eq("bucket", bucket)).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	if db.DriverName() != "sqlite" {
+		selectSQL += " FOR UPDATE"
+	}
+
+	var blob []byte
+	if err := tx.QueryRow(ctx, selectSQL, args...).Scan(&blob); err != nil {
+		return nil, err
+	}
+
+	sketch := new(hyperLogLog)
+	if err := sketch.UnmarshalBinary(blob); err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
+
+func saveSketch(ctx context.Context, db database.Database, tx database.Tx, m Metric, bucket string, sketch *hyperLogLog) error {
+	blob, _ := sketch.MarshalBinary()
+	sqlStr, args, err := query.New(db.Dialect()).
+		Update(sketchesTableName).
+		Set("sketch", blob).
+		Where(metricCondition(m)).
+		Where(query.Eq("bucket", bucket)).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, sqlStr, args...)
+	return err
+}
+
+// countUniqueVisitors merges the day sketches of a unique metric between from
+// and to (inclusive, either may be empty for an open range) and returns the
+// number of distinct visitors they saw.
+func countUniqueVisitors(ctx context.Context, db database.Database, m Metric, from, to string) (uint64, error) {
+	qb := query.New(db.Dialect()).
+		Select("sketch").
+		From(sketchesTableName).
+		Where(metricCondition(m)).
+		Where(query.Ne("bucket", uniqueBucketAll))
+	if from != "" {
+		qb = qb.Where(query.Gte("bucket", from))
+	}
+	if to != "" {
+		qb = qb.Where(query.Lte("bucket", to))
+	}
+
+	sqlStr, args, err := qb.Build()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query(ctx, sqlStr, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var merged, sketch hyperLogLog
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return 0, err
+		}
+		if err := sketch.UnmarshalBinary(blob); err != nil {
+			return 0, err
+		}
+		merged.merge(&sketch)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return merged.estimate(), nil
+}
+
+// UniqueResource serves the distinct visitor count of a unique metric over a
+// range of days.
+type UniqueResource struct {
+	db           database.Database
+	config       *Config
+	hooks        *MetricHooks
+	converter    *MetricConverter
+	errorHandler processor.ErrorHandler
+}
+
+func RegisterUniqueRoutes(router fiber.Router, db database.Database, config *Config) {
+	res := &UniqueResource{
+		db:           db,
+		config:       config,
+		hooks:        NewMetricHooks(config),
+		converter:    &MetricConverter{},
+		errorHandler: &processor.DefaultErrorHandler{},
+	}
+
+	router.Get("/metrics/unique/:resource/:resourceId/:key", res.Get)
+}
+
+// Get answers GET /metrics/unique/:resource/:resourceId/:key?from=&to= with
+// the metric, its value being the distinct visitors seen between the from and
+// to days (YYYY-MM-DD, inclusive). Without a range it is the all-time count.
+func (r *UniqueResource) Get(c fiber.Ctx) error {
+	resourceType := c.Params("resource")
+	resourceID := c.Params("resourceId")
+
+	key, err := r.hooks.validateTarget(resourceType, resourceID, c.Params("key"))
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "validate")
+	}
+	if !r.config.IsUniqueKey(key) {
+		return r.errorHandler.HandleError(c, fiber.NewError(400, "key is not a unique metric"), "validate")
+	}
+
+	err = r.config.authorize(c, AuthorizationRequest{
+		Operation:    OperationRead,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Key:          key,
+	})
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	from, to := c.Query("from"), c.Query("to")
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(uniqueBucketDate, day); err != nil {
+			return r.errorHandler.HandleError(c, fiber.NewError(400, "from and to must be dates formatted as YYYY-MM-DD"), "validate")
+		}
+	}
+
+	ctx := auth.Context(c)
+	tenant, err := r.config.tenant(c)
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "hook")
+	}
+
+	m, err := fetchMetric(ctx, r.db, Metric{Tenant: tenant, Resource: resourceType, ResourceId: resourceID, Key: key})
+	if err != nil {
+		return r.errorHandler.HandleError(c, err, "getById")
+	}
+	if m == nil || m.DeletedAt != nil || !r.config.canRead(c, *m) {
+		return r.errorHandler.HandleError(c, fiber.NewError(404, "metric not found"), "getById")
+	}
+
+	if from != "" || to != "" {
+		count, err := countUniqueVisitors(ctx, r.db, *m, from, to)
+		if err != nil {
+			return r.errorHandler.HandleError(c, err, "getById")
+		}
+		m.Value = int(count)
+	}
+
+	return response.SendFormatted(c, fiber.StatusOK, r.converter.ModelToResponseDTO(*m))
+}
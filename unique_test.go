@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uniqueTestConfig() Config {
+	config := DefaultConfig()
+	config.UniqueKeys = []string{"viewers"}
+	return config
+}
+
+func TestMetricResource_CreateUnique(t *testing.T) {
+	db := newTestDB(t)
+	config := uniqueTestConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterRoutes(app, db, &config, writer, newMetricHub(0), nil, nil, nil, nil)
+
+	resourceID := uuid.New().String()
+	visit := func(visitor string) (int, []byte) {
+		return doJSON(t, app, http.MethodPost, "/metrics", MetricCreateDTO{
+			Resource: "post", ResourceId: resourceID, Key: "viewers", Visitor: visitor,
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, visitor := range []string{"alice", "bob", "carol"} {
+			status, body := visit(visitor)
+			require.Equal(t, http.StatusAccepted, status, string(body))
+		}
+	}
+	require.NoError(t, writer.shutdown(context.Background()))
+
+	m, err := fetchMetric(context.Background(), db, Metric{Resource: "post", ResourceId: resourceID, Key: "viewers"})
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, 3, m.Value, "refreshes are not counted twice")
+	assert.Equal(t, 2, countRows(t, db, sketchesTableName), "today's bucket and the all-time bucket")
+
+	status, body := doJSON(t, app, http.MethodGet, "/metrics/"+m.Id, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var got MetricResponseDTO
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, 3, got.Value)
+
+	status, body = doJSON(t, app, http.MethodPut, "/metrics/"+m.Id, MetricUpdateDTO{Value: 10})
+	assert.Equal(t, http.StatusBadRequest, status, string(body))
+	assert.Contains(t, string(body), "unique metrics cannot be updated")
+}
+
+func TestMetricResource_CreateUniqueValidation(t *testing.T) {
+	db := newTestDB(t)
+	config := uniqueTestConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	defer func() { _ = writer.shutdown(context.Background()) }()
+	app := fiber.New()
+	RegisterMetricRoutes(app, db, &config, writer, nil, nil, nil, nil)
+
+	resourceID := uuid.New().String()
+	tests := []struct {
+		name string
+		dto  MetricCreateDTO
+		want string
+	}{
+		{
+			name: "missing visitor",
+			dto:  MetricCreateDTO{Resource: "post", ResourceId: resourceID, Key: "viewers"},
+			want: "visitor is required for unique metrics",
+		},
+		{
+			name: "value on unique key",
+			dto:  MetricCreateDTO{Resource: "post", ResourceId: resourceID, Key: "viewers", Visitor: "alice", Value: 3},
+			want: "value cannot be set on unique metrics",
+		},
+		{
+			name: "visitor on counter key",
+			dto:  MetricCreateDTO{Resource: "post", ResourceId: resourceID, Key: "views", Visitor: "alice"},
+			want: "visitor is only accepted for unique metrics",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doJSON(t, app, http.MethodPost, "/metrics", tt.dto)
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.Contains(t, string(body), tt.want)
+		})
+	}
+}
+
+func TestUniqueResource_MergesDayBuckets(t *testing.T) {
+	db := newTestDB(t)
+	config := uniqueTestConfig()
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+
+	resourceID := uuid.New().String()
+	visit := func(day string, from, to int) {
+		at, err := time.Parse(time.DateOnly, day)
+		require.NoError(t, err)
+		for i := from; i < to; i++ {
+			writer.enqueueUnique(Metric{
+				Id: uuid.New().String(), Resource: "post", ResourceId: resourceID, Key: "viewers", CreatedAt: &at,
+			}, fmt.Sprintf("visitor-%d", i))
+		}
+	}
+	visit("2026-10-01", 0, 100)
+	visit("2026-10-02", 50, 150)
+	visit("2026-10-03", 1000, 1010)
+	require.NoError(t, writer.shutdown(context.Background()))
+
+	app := fiber.New()
+	RegisterUniqueRoutes(app, db, &config)
+	target := "/metrics/unique/post/" + resourceID + "/viewers"
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "all time", query: "", want: 160},
+		{name: "one day", query: "?from=2026-10-02&to=2026-10-02", want: 100},
+		{name: "two days overlap", query: "?from=2026-10-01&to=2026-10-02", want: 150},
+		{name: "open start", query: "?to=2026-10-01", want: 100},
+		{name: "open end", query: "?from=2026-10-03", want: 10},
+		{name: "empty range", query: "?from=2026-11-01", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doJSON(t, app, http.MethodGet, target+tt.query, nil)
+			require.Equal(t, http.StatusOK, status, string(body))
+
+			var got MetricResponseDTO
+			require.NoError(t, json.Unmarshal(body, &got))
+			assert.Equal(t, resourceID, got.ResourceID)
+			assert.Equal(t, "viewers", got.Key)
+			assert.InDelta(t, tt.want, got.Value, 3)
+		})
+	}
+
+	status, _ := doGet(t, app, target+"?from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doGet(t, app, "/metrics/unique/post/"+resourceID+"/views")
+	assert.Equal(t, http.StatusBadRequest, status, "not a unique key")
+
+	status, _ = doGet(t, app, "/metrics/unique/post/"+uuid.New().String()+"/viewers")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestMetricResource_DeleteUniqueKeepsSketches(t *testing.T) {
+	db := newTestDB(t)
+	p := &MetricsPlugin{config: uniqueTestConfig(), db: db}
+	p.writer = newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	rec := p.Recorder()
+	ctx := context.Background()
+
+	kept := uuid.New().String()
+	deleted := uuid.New().String()
+	require.NoError(t, rec.AddVisitor("post", kept, "viewers", "alice"))
+	require.NoError(t, rec.AddVisitor("post", deleted, "viewers", "alice"))
+	require.NoError(t, rec.AddVisitor("post", deleted, "viewers", "bob"))
+	require.NoError(t, p.writer.shutdown(ctx))
+
+	m, err := fetchMetric(ctx, db, Metric{Resource: "post", ResourceId: deleted, Key: "viewers"})
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, 2, m.Value)
+
+	app := fiber.New()
+	app.Use(withRoles(defaultAdminRole))
+	RegisterMetricRoutes(app, db, &p.config, p.writer, nil, nil, nil, nil)
+	status, body := doJSON(t, app, http.MethodDelete, "/metrics/"+m.Id, nil)
+	require.Less(t, status, 300, string(body))
+
+	assert.Equal(t, 4, countRows(t, db, sketchesTableName), "soft deletes keep the sketches for a restore")
+
+	require.NoError(t, p.DeleteForResource(ctx, "", "post", kept))
+	assert.Equal(t, 2, countRows(t, db, sketchesTableName))
+	require.NoError(t, p.DeleteForResource(ctx, "", "post", deleted))
+	assert.Equal(t, 0, countRows(t, db, sketchesTableName))
+}
+
+func TestRecorder_UniqueKeys(t *testing.T) {
+	rec, w := newTestRecorder(t, uniqueTestConfig())
+	defer func() { _ = w.shutdown(context.Background()) }()
+	id := uuid.New().String()
+
+	assert.EqualError(t, rec.AddVisitor("post", id, "views", "alice"), "key is not a unique metric")
+	assert.EqualError(t, rec.AddVisitor("post", id, "viewers", " "), "visitor is required for unique metrics")
+	assert.EqualError(t, rec.Increment("post", id, "viewers", 1), "visitor is required for unique metrics")
+}
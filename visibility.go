@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Visibilities accepted in key_visibility. Keys without an entry are public.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// IsPrivateKey reports whether key is only readable by callers authorized for
+// OperationReadPrivate.
+func (c *Config) IsPrivateKey(key string) bool {
+	return c.KeyVisibility[key] == VisibilityPrivate
+}
+
+// privateKeys returns the private keys, sorted so the queries built from them
+// are stable.
+func (c *Config) privateKeys() []string {
+	keys := make([]string, 0, len(c.KeyVisibility))
+	for key, visibility := range c.KeyVisibility {
+		if visibility == VisibilityPrivate {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hiddenKeys returns the private keys the caller of ctx may not read.
+func (c *Config) hiddenKeys(ctx fiber.Ctx) []string {
+	var hidden []string
+	for _, key := range c.privateKeys() {
+		if c.authorize(ctx, AuthorizationRequest{Operation: OperationReadPrivate, Key: key}) != nil {
+			hidden = append(hidden, key)
+		}
+	}
+	return hidden
+}
+
+// canRead reports whether the caller of ctx may see m, which is only in
+// question for private keys.
+func (c *Config) canRead(ctx fiber.Ctx, m Metric) bool {
+	if !c.IsPrivateKey(m.Key) {
+		return true
+	}
+	err := c.authorize(ctx, AuthorizationRequest{
+		Operation:    OperationReadPrivate,
+		ResourceType: m.Resource,
+		ResourceID:   m.ResourceId,
+		Key:          m.Key,
+	})
+	return err == nil
+}
+
+// hideKeys wraps matcher so metrics of the hidden keys are never delivered.
+func hideKeys(matcher metricMatcher, hidden []string) metricMatcher {
+	if len(hidden) == 0 {
+		return matcher
+	}
+	set := make(map[string]bool, len(hidden))
+	for _, key := range hidden {
+		set[key] = true
+	}
+	return keyHidingMatcher{matcher: matcher, hidden: set}
+}
+
+type keyHidingMatcher struct {
+	matcher metricMatcher
+	hidden  map[string]bool
+}
+
+func (m keyHidingMatcher) matches(metric Metric) bool {
+	return !m.hidden[metric.Key] && m.matcher.matches(metric)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_IsPrivateKey(t *testing.T) {
+	config := DefaultConfig()
+	config.KeyVisibility = map[string]string{
+		"revenue": VisibilityPrivate,
+		"margin":  VisibilityPrivate,
+		"views":   VisibilityPublic,
+	}
+
+	assert.True(t, config.IsPrivateKey("revenue"))
+	assert.False(t, config.IsPrivateKey("views"))
+	assert.False(t, config.IsPrivateKey("likes"), "unlisted keys are public")
+	assert.Equal(t, []string{"margin", "revenue"}, config.privateKeys())
+}
+
+func TestHideKeys(t *testing.T) {
+	all := metricFilter{}
+	assert.Equal(t, all, hideKeys(all, nil), "nothing to hide keeps the matcher")
+
+	matcher := hideKeys(all, []string{"revenue"})
+	assert.True(t, matcher.matches(Metric{Resource: "post", Key: "views"}))
+	assert.False(t, matcher.matches(Metric{Resource: "post", Key: "revenue"}))
+}
+
+func TestMetricResource_PrivateKeys(t *testing.T) {
+	db := newTestDB(t)
+	resourceID := uuid.New().String()
+	insertMetric(t, db, resourceID, "views")
+	insertMetric(t, db, resourceID, "revenue")
+	revenue, err := fetchMetric(context.Background(), db, Metric{Resource: "post", ResourceId: resourceID, Key: "revenue"})
+	require.NoError(t, err)
+	require.NotNil(t, revenue)
+
+	config := DefaultConfig()
+	config.KeyVisibility = map[string]string{"revenue": VisibilityPrivate}
+	require.NoError(t, config.Validate())
+	writer := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	t.Cleanup(func() { _ = writer.shutdown(context.Background()) })
+
+	public := fiber.New()
+	public.Use(withRoles("user"))
+	RegisterRoutes(public, db, &config, writer, newMetricHub(0), nil, nil, nil, nil)
+	admin := fiber.New()
+	admin.Use(withRoles(defaultAdminRole))
+	RegisterRoutes(admin, db, &config, writer, newMetricHub(0), nil, nil, nil, nil)
+
+	status, body := doJSON(t, public, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), `"views"`)
+	assert.NotContains(t, string(body), `"revenue"`)
+
+	status, body = doJSON(t, public, http.MethodGet, "/metrics?name=revenue", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.NotContains(t, string(body), `"revenue"`, "filters cannot reach private keys")
+
+	status, body = doJSON(t, public, http.MethodGet, "/metrics/"+revenue.Id, nil)
+	assert.Equal(t, http.StatusNotFound, status, string(body))
+
+	status, body = doJSON(t, public, http.MethodGet, "/metrics/resources/post/"+resourceID, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var values map[string]int
+	require.NoError(t, json.Unmarshal(body, &values))
+	assert.Equal(t, map[string]int{"views": 1}, values)
+
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.Contains(t, string(body), `"revenue"`)
+
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics/"+revenue.Id, nil)
+	assert.Equal(t, http.StatusOK, status, string(body))
+
+	status, body = doJSON(t, admin, http.MethodGet, "/metrics/resources/post/"+resourceID, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.NoError(t, json.Unmarshal(body, &values))
+	assert.Equal(t, map[string]int{"views": 1, "revenue": 1}, values)
+}
+
+func TestSocketResource_RejectsPrivateKeys(t *testing.T) {
+	config := DefaultConfig()
+	res := &SocketResource{hooks: NewMetricHooks(&config)}
+	topics := &socketTopics{topics: make(map[socketTopic]struct{}), hidden: map[string]bool{"revenue": true}}
+
+	resourceID := uuid.New().String()
+	reply := res.handle(topics, SocketRequest{Action: SocketActionSubscribe, Resource: "post", ResourceId: resourceID, Key: "revenue"})
+	assert.Equal(t, SocketMessageError, reply.Type)
+	assert.Equal(t, "key is private", reply.Message)
+
+	reply = res.handle(topics, SocketRequest{Action: SocketActionSubscribe, Resource: "post", ResourceId: resourceID, Key: "views"})
+	assert.Equal(t, SocketMessageSubscribed, reply.Type)
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -24,6 +25,34 @@ type batchWriterOptions struct {
 	batchSize      int
 	flushInterval  time.Duration
 	writeTimeout   time.Duration
+
+	// flushListeners are called from the writer goroutine with the metrics of
+	// each flush that were actually persisted. They must not block or retain
+	// the slice.
+	flushListeners []func([]Metric)
+}
+
+// writeMode selects how a pending write is applied to its metric row.
+type writeMode int
+
+const (
+	// writeInsert creates the row; a second insert of the same tuple is
+	// rejected by the unique constraint, as with POST /metrics.
+	writeInsert writeMode = iota
+	// writeIncrement adds the value to the row, creating it if needed.
+	writeIncrement
+	// writeSet overwrites the row's value, creating it if needed.
+	writeSet
+	// writeUnique adds a visitor to the tuple's HyperLogLog sketches and sets
+	// the row's value to the new distinct count.
+	writeUnique
+)
+
+type pendingWrite struct {
+	metric Metric
+	mode   writeMode
+	// visitor is the hashed visitor of a writeUnique.
+	visitor uint64
 }
 
 // batchWriter keeps metric inserts off the request hot path by buffering them
@@ -32,10 +61,11 @@ type batchWriterOptions struct {
 // synchronous INSERT round trip (plus, previously, a follow-up SELECT).
 type batchWriter struct {
 	db        database.Database
-	buf       chan Metric
+	buf       chan pendingWrite
 	batchSize int
 	interval  time.Duration
 	timeout   time.Duration
+	listeners []func([]Metric)
 
 	wg sync.WaitGroup
 
@@ -61,10 +91,11 @@ func newBatchWriter(db database.Database, opts batchWriterOptions) *batchWriter
 
 	w := &batchWriter{
 		db:        db,
-		buf:       make(chan Metric, opts.bufferCapacity),
+		buf:       make(chan pendingWrite, opts.bufferCapacity),
 		batchSize: opts.batchSize,
 		interval:  opts.flushInterval,
 		timeout:   opts.writeTimeout,
+		listeners: opts.flushListeners,
 	}
 
 	w.wg.Add(1)
@@ -78,12 +109,26 @@ func newBatchWriter(db database.Database, opts batchWriterOptions) *batchWriter
 // events. Once the writer is shut down it silently discards the event: the
 // HTTP server stops serving before shutdown, so no live request reaches here.
 func (w *batchWriter) enqueue(m Metric) {
+	w.enqueueWrite(m, writeInsert)
+}
+
+// enqueueWrite is enqueue for increments and overwrites.
+func (w *batchWriter) enqueueWrite(m Metric, mode writeMode) {
+	w.push(pendingWrite{metric: m, mode: mode})
+}
+
+// enqueueUnique is enqueue for a visitor of a unique metric.
+func (w *batchWriter) enqueueUnique(m Metric, visitor string) {
+	w.push(pendingWrite{metric: m, mode: writeUnique, visitor: hashVisitor(visitor)})
+}
+
+func (w *batchWriter) push(p pendingWrite) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 	if w.closed {
 		return
 	}
-	w.buf <- m
+	w.buf <- p
 }
 
 func (w *batchWriter) run() {
@@ -92,12 +137,12 @@ func (w *batchWriter) run() {
 	ticker := time.NewTicker(w.interval)
 	defer ticker.Stop()
 
-	batch := make([]Metric, 0, w.batchSize)
+	batch := make([]pendingWrite, 0, w.batchSize)
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
-		w.writeBatch(batch)
+		w.notify(w.writeBatch(batch))
 		batch = batch[:0]
 	}
 
@@ -147,17 +192,58 @@ func (w *batchWriter) shutdown(ctx context.Context) error {
 	}
 }
 
-func (w *batchWriter) writeBatch(batch []Metric) {
+// writeBatch persists batch and returns the metrics that made it to the
+// database. Increments, overwrites and unique visitors are reported with the
+// resulting value. Every metric written is stamped with the flush time as
+// updated_at.
+func (w *batchWriter) writeBatch(batch []pendingWrite) []Metric {
 	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
 	defer cancel()
 
+	now := time.Now().UTC().Truncate(time.Second)
+	inserts := make([]Metric, 0, len(batch))
+	var upserts, uniques []pendingWrite
+	for _, p := range batch {
+		p.metric.UpdatedAt = &now
+		switch p.mode {
+		case writeInsert:
+			inserts = append(inserts, p.metric)
+		case writeUnique:
+			uniques = append(uniques, p)
+		default:
+			upserts = append(upserts, p)
+		}
+	}
+
+	persisted := w.writeInserts(ctx, inserts)
+	for _, p := range coalesceWrites(upserts) {
+		m, err := w.execUpsert(ctx, p)
+		if err != nil {
+			logger.Log.Error("metrics: failed to persist metric",
+				"error", err,
+				"resource", p.metric.Resource,
+				"key", p.metric.Key,
+			)
+			continue
+		}
+		persisted = append(persisted, m)
+	}
+	return append(persisted, w.writeUniques(ctx, uniques)...)
+}
+
+func (w *batchWriter) writeInserts(ctx context.Context, batch []Metric) []Metric {
+	if len(batch) == 0 {
+		return nil
+	}
+
 	if err := w.execInsert(ctx, batch); err == nil {
-		return
+		return batch
 	}
 
 	// A single offending row (e.g. a unique-constraint violation) fails the
 	// whole multi-row statement, so retry row by row to isolate the bad one
 	// and still persist every valid event.
+	persisted := make([]Metric, 0, len(batch))
 	for i := range batch {
 		if err := w.execInsert(ctx, batch[i:i+1]); err != nil {
 			logger.Log.Error("metrics: failed to persist metric",
@@ -165,17 +251,114 @@ func (w *batchWriter) writeBatch(batch []Metric) {
 				"resource", batch[i].Resource,
 				"key", batch[i].Key,
 			)
+			continue
+		}
+		persisted = append(persisted, batch[i])
+	}
+	return persisted
+}
+
+// notify hands persisted metrics to the flush listeners. Writes to a deleted
+// metric are still applied, so restoring it loses nothing, but they are not
+// announced.
+func (w *batchWriter) notify(persisted []Metric) {
+	live := persisted[:0]
+	for _, m := range persisted {
+		if m.DeletedAt == nil {
+			live = append(live, m)
+		}
+	}
+	persisted = live
+
+	if len(persisted) == 0 {
+		return
+	}
+	for _, listener := range w.listeners {
+		listener(persisted)
+	}
+}
+
+// coalesceWrites folds the increments and overwrites of one tuple into a
+// single write, preserving their order: an overwrite followed by increments
+// becomes an overwrite of the sum.
+func coalesceWrites(writes []pendingWrite) []pendingWrite {
+	type tuple struct{ tenant, resource, resourceID, key string }
+
+	index := make(map[tuple]int, len(writes))
+	out := make([]pendingWrite, 0, len(writes))
+	for _, p := range writes {
+		t := tuple{p.metric.Tenant, p.metric.Resource, p.metric.ResourceId, p.metric.Key}
+		i, ok := index[t]
+		if !ok {
+			index[t] = len(out)
+			out = append(out, p)
+			continue
+		}
+		if p.mode == writeSet {
+			out[i].mode = writeSet
+			out[i].metric.Value = p.metric.Value
+		} else {
+			out[i].metric.Value += p.metric.Value
+		}
+	}
+	return out
+}
+
+// execUpsert applies an increment or overwrite and returns the stored row.
+func (w *batchWriter) execUpsert(ctx context.Context, p pendingWrite) (Metric, error) {
+	dialect := w.db.Dialect()
+	m := p.metric
+
+	insertSQL, args, err := query.New(dialect).
+		Insert(Metric{}.TableName()).
+		Columns("id", "tenant", "resource", "resource_id", "name", "value", "updated_at").
+		Values(m.Id, m.Tenant, m.Resource, m.ResourceId, m.Key, m.Value, m.UpdatedAt).
+		Build()
+	if err != nil {
+		return Metric{}, err
+	}
+
+	// Every write bumps version, which is the ETag of the metric, and
+	// updated_at.
+	value, version := dialect.QuoteIdentifier("value"), dialect.QuoteIdentifier("version")
+	updatedAt := dialect.QuoteIdentifier("updated_at")
+	var update string
+	if w.db.DriverName() == "mysql" {
+		update = fmt.Sprintf("%s = VALUES(%s)", value, value)
+		if p.mode == writeIncrement {
+			update = fmt.Sprintf("%s = %s + VALUES(%s)", value, value, value)
+		}
+		update = fmt.Sprintf("ON DUPLICATE KEY UPDATE %s, %s = %s + 1, %s = VALUES(%s)",
+			update, version, version, updatedAt, updatedAt)
+	} else {
+		table := dialect.QuoteIdentifier(Metric{}.TableName())
+		action := fmt.Sprintf("DO UPDATE SET %s = excluded.%s", value, value)
+		if p.mode == writeIncrement {
+			action = fmt.Sprintf("DO UPDATE SET %s = %s.%s + excluded.%s", value, table, value, value)
 		}
+		action = fmt.Sprintf("%s, %s = %s.%s + 1, %s = excluded.%s",
+			action, version, table, version, updatedAt, updatedAt)
+		update = dialect.OnConflictClause([]string{"tenant", "resource", "resource_id", "name"}, action)
+	}
+
+	if _, err := w.db.Exec(ctx, insertSQL+" "+update, args...); err != nil {
+		return Metric{}, err
+	}
+
+	stored, err := fetchMetric(ctx, w.db, m)
+	if err != nil {
+		return Metric{}, err
 	}
+	return *stored, nil
 }
 
 func (w *batchWriter) execInsert(ctx context.Context, batch []Metric) error {
 	qb := query.New(w.db.Dialect()).
 		Insert(Metric{}.TableName()).
-		Columns("id", "resource", "resource_id", "name", "value")
+		Columns("id", "tenant", "resource", "resource_id", "name", "value", "updated_at")
 
 	for _, m := range batch {
-		qb = qb.Values(m.Id, m.Resource, m.ResourceId, m.Key, m.Value)
+		qb = qb.Values(m.Id, m.Tenant, m.Resource, m.ResourceId, m.Key, m.Value, m.UpdatedAt)
 	}
 
 	sqlStr, args, err := qb.Build()
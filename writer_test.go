@@ -2,46 +2,14 @@ package metrics
 
 import (
 	"context"
-	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/nicolasbonnici/gorest/database"
-	_ "github.com/nicolasbonnici/gorest/database/sqlite"
 )
 
-func newTestDB(t *testing.T) database.Database {
-	t.Helper()
-
-	// busy_timeout + WAL let the pooled writer/reader connections coexist on a
-	// file-backed SQLite DB without spurious SQLITE_BUSY during the test.
-	path := filepath.Join(t.TempDir(), "metrics_test.db")
-	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
-	db, err := database.Open("sqlite", dsn)
-	if err != nil {
-		t.Fatalf("open sqlite: %v", err)
-	}
-	t.Cleanup(func() { _ = db.Close() })
-
-	ctx := context.Background()
-	_, err = db.Exec(ctx, `CREATE TABLE metrics (
-		id TEXT PRIMARY KEY,
-		resource TEXT NOT NULL,
-		resource_id TEXT NOT NULL,
-		name TEXT NOT NULL,
-		value INTEGER NOT NULL DEFAULT 0,
-		created_at TEXT NOT NULL DEFAULT (datetime('now')),
-		UNIQUE (resource, resource_id, name)
-	)`)
-	if err != nil {
-		t.Fatalf("create table: %v", err)
-	}
-
-	return db
-}
-
 func countMetrics(t *testing.T, db database.Database) int {
 	t.Helper()
 	var n int
@@ -177,3 +145,38 @@ func TestBatchWriter_ShutdownIsIdempotent(t *testing.T) {
 		t.Fatalf("expected no metrics after post-shutdown enqueue, got %d", got)
 	}
 }
+
+func TestBatchWriter_StampsUpdatedAt(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	m := sampleMetric()
+
+	w := newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	w.enqueue(m)
+	if err := w.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	past := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := db.Exec(ctx, rebind(db, "UPDATE metrics SET created_at = ?, updated_at = ?"), past, past); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+
+	w = newBatchWriter(db, batchWriterOptions{flushInterval: time.Hour})
+	m.Id = uuid.New().String()
+	w.enqueueWrite(m, writeIncrement)
+	if err := w.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	var createdAt, updatedAt time.Time
+	if err := db.QueryRow(ctx, "SELECT created_at, updated_at FROM metrics").Scan(&createdAt, &updatedAt); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if !createdAt.Equal(past) {
+		t.Fatalf("created_at = %v, want it unchanged at %v", createdAt, past)
+	}
+	if !updatedAt.After(past) {
+		t.Fatalf("updated_at = %v, want it bumped past %v", updatedAt, past)
+	}
+}